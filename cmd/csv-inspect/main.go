@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/csvio"
+)
+
+// utf8BOM is the byte order mark some spreadsheet exports put before the header
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// Describe what would trip up a header lookup: surrounding whitespace, invisible characters
+// or an empty name
+func headerProblems(header string) []string {
+	var problems []string
+	if header == "" {
+		return []string{"empty name"}
+	}
+	if strings.TrimSpace(header) != header {
+		problems = append(problems, "surrounding whitespace")
+	}
+	for _, r := range header {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			problems = append(problems, "invisible characters")
+			break
+		}
+	}
+	return problems
+}
+
+// Join indices as a comma-separated list
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprint(value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func main() {
+	// Define command-line flags
+	csvPath := flag.String("csv", "", "CSV file whose header row is inspected (required)")
+	columns := flag.String("columns", "", "Comma-separated columns the header must contain, named as they are passed to the attachers")
+	comment := flag.String("comment", "", "Skip lines starting with this character before the header, as with the attachers' -comment")
+	flag.Parse()
+
+	if *csvPath == "" {
+		fmt.Println("Error: -csv is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	file, err := os.Open(*csvPath)
+	if err != nil {
+		fmt.Printf("Error opening CSV file: %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	// The attachers don't strip a byte order mark, so it becomes part of the first header
+	input := bufio.NewReader(file)
+	head, _ := input.Peek(len(utf8BOM))
+	hasBOM := bytes.Equal(head, utf8BOM)
+
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.Comment, err = csvio.ParseComment(*comment)
+	if err != nil {
+		fmt.Printf("Error: -comment: %v\n", err)
+		os.Exit(1)
+	}
+	headers, err := reader.Read()
+	if err != nil {
+		fmt.Printf("Error reading header: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Print each column with its index, noting names a lookup would miss
+	issueCount := 0
+	positions := make(map[string][]int)
+	fmt.Printf("Header of %s: %d columns\n", *csvPath, len(headers))
+	for i, header := range headers {
+		positions[header] = append(positions[header], i)
+		if i == 0 && hasBOM {
+			header = strings.TrimPrefix(header, "\ufeff") // Reported once, by the warning below
+		}
+		problems := headerProblems(header)
+		if len(problems) == 0 {
+			fmt.Printf("%4d  %s\n", i, header)
+			continue
+		}
+		issueCount++
+		fmt.Printf("%4d  %q  (%s)\n", i, header, strings.Join(problems, ", "))
+	}
+
+	if hasBOM {
+		issueCount++
+		fmt.Printf("WARNING: file starts with a UTF-8 byte order mark, so column 0 is named %q; re-save the CSV without a BOM\n", headers[0])
+	}
+
+	// Lookups always find the first of several columns with the same name
+	for i, header := range headers {
+		if indices := positions[header]; len(indices) > 1 && indices[0] == i {
+			issueCount++
+			fmt.Printf("WARNING: duplicate column %q at indices %s; lookups use index %d\n", header, joinInts(indices), indices[0])
+		}
+	}
+
+	// Check the expected columns, pointing out near misses that -normalize-headers would match
+	for _, column := range strings.Split(*columns, ",") {
+		if column = strings.TrimSpace(column); column == "" {
+			continue
+		}
+		if indices, found := positions[column]; found {
+			fmt.Printf("OK   column %q at index %d\n", column, indices[0])
+			continue
+		}
+		issueCount++
+		near := -1
+		for i, header := range headers {
+			if strings.EqualFold(strings.TrimSpace(header), column) {
+				near = i
+				break
+			}
+		}
+		if near != -1 {
+			fmt.Printf("FAIL column %q not found; index %d %q only matches ignoring case and surrounding whitespace (try -normalize-headers)\n", column, near, headers[near])
+		} else {
+			fmt.Printf("FAIL column %q not found; an attacher would append it as a new column\n", column)
+		}
+	}
+
+	fmt.Printf("Inspection summary: %d columns, %d issues\n", len(headers), issueCount)
+	if issueCount > 0 {
+		os.Exit(1)
+	}
+}
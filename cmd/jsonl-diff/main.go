@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/dotpath"
+)
+
+// Record statuses reported in the per-record diff
+const (
+	statusAdded   = "added"
+	statusRemoved = "removed"
+	statusChanged = "changed"
+)
+
+// fieldChange describes one field whose value differs between the two files. Fields are
+// dotted paths into nested objects; a missing side is omitted.
+type fieldChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old,omitempty"`
+	New   json.RawMessage `json:"new,omitempty"`
+}
+
+// recordDiff is one line of the -output file
+type recordDiff struct {
+	Key     string        `json:"key"`
+	Status  string        `json:"status"`
+	Changes []fieldChange `json:"changes,omitempty"`
+}
+
+// recordSet holds the keyed records of one JSONL file in file order
+type recordSet struct {
+	Records    map[string]map[string]interface{}
+	Order      []string
+	Lines      int
+	Invalid    int // Lines that aren't JSON objects
+	Unkeyed    int // Records without a string key, which can't be compared
+	Duplicates int // Records whose key repeats an earlier one; the last occurrence wins
+}
+
+// Read a JSONL file, keying each record by the string value at keyPath as jsonl-splitter
+// names its files
+func loadRecords(path string, keyPath string) (*recordSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	set := &recordSet{Records: make(map[string]map[string]interface{})}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // Profiles can be long lines
+	for scanner.Scan() {
+		set.Lines++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			set.Invalid++
+			continue
+		}
+		value, ok := dotpath.Lookup(record, dotpath.Split(keyPath))
+		key, isString := value.(string)
+		if !ok || !isString || key == "" {
+			set.Unkeyed++
+			continue
+		}
+
+		if _, exists := set.Records[key]; exists {
+			set.Duplicates++
+		} else {
+			set.Order = append(set.Order, key)
+		}
+		set.Records[key] = record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return set, nil
+}
+
+// Flatten nested objects into dotted paths with JSON-encoded values. Arrays are compared
+// as a whole.
+func flattenRecord(prefix string, value interface{}, out map[string]json.RawMessage) {
+	if object, ok := value.(map[string]interface{}); ok && (prefix == "" || len(object) > 0) {
+		for name, child := range object {
+			path := name
+			if prefix != "" {
+				path = prefix + "." + name
+			}
+			flattenRecord(path, child, out)
+		}
+		return
+	}
+	encoded, _ := json.Marshal(value) // Values decoded from JSON always re-encode
+	out[prefix] = encoded
+}
+
+// Compare two records field by field, returning the changes sorted by field
+func diffRecords(oldRecord, newRecord map[string]interface{}) []fieldChange {
+	oldFields := make(map[string]json.RawMessage)
+	newFields := make(map[string]json.RawMessage)
+	flattenRecord("", oldRecord, oldFields)
+	flattenRecord("", newRecord, newFields)
+
+	var changes []fieldChange
+	for field, oldValue := range oldFields {
+		newValue, exists := newFields[field]
+		if !exists {
+			changes = append(changes, fieldChange{Field: field, Old: oldValue})
+		} else if string(oldValue) != string(newValue) {
+			changes = append(changes, fieldChange{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	for field, newValue := range newFields {
+		if _, exists := oldFields[field]; !exists {
+			changes = append(changes, fieldChange{Field: field, New: newValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func main() {
+	// Define command-line flags
+	oldPath := flag.String("old", "", "Earlier JSONL file (required)")
+	newPath := flag.String("new", "", "Later JSONL file (required)")
+	keyPath := flag.String("key", "publicIdentifier", "Field identifying a record; use dots for nested fields (e.g. profile.publicIdentifier)")
+	outputPath := flag.String("output", "", "Write a per-record diff as JSONL to this path")
+	topFields := flag.Int("top-fields", 10, "Number of most frequently changed fields to list in the summary")
+	flag.Parse()
+
+	if *oldPath == "" || *newPath == "" {
+		fmt.Println("Error: -old and -new are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	oldSet, err := loadRecords(*oldPath, *keyPath)
+	if err != nil {
+		fmt.Printf("Error loading old file: %v\n", err)
+		os.Exit(1)
+	}
+	newSet, err := loadRecords(*newPath, *keyPath)
+	if err != nil {
+		fmt.Printf("Error loading new file: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Compare in the new file's order, then list removals in the old file's order
+	var diffs []recordDiff
+	unchangedCount := 0
+	fieldCounts := make(map[string]int)
+	for _, key := range newSet.Order {
+		oldRecord, exists := oldSet.Records[key]
+		if !exists {
+			diffs = append(diffs, recordDiff{Key: key, Status: statusAdded})
+			continue
+		}
+		changes := diffRecords(oldRecord, newSet.Records[key])
+		if len(changes) == 0 {
+			unchangedCount++
+			continue
+		}
+		for _, change := range changes {
+			fieldCounts[change.Field]++
+		}
+		diffs = append(diffs, recordDiff{Key: key, Status: statusChanged, Changes: changes})
+	}
+	for _, key := range oldSet.Order {
+		if _, exists := newSet.Records[key]; !exists {
+			diffs = append(diffs, recordDiff{Key: key, Status: statusRemoved})
+		}
+	}
+
+	// Write the per-record diff
+	if *outputPath != "" {
+		output, err := os.Create(*outputPath)
+		if err != nil {
+			fmt.Printf("Error creating output file: %v\n", err)
+			os.Exit(1)
+		}
+		writer := bufio.NewWriter(output)
+		encoder := json.NewEncoder(writer)
+		for _, diff := range diffs {
+			if err := encoder.Encode(diff); err != nil {
+				fmt.Printf("Error writing diff for %s: %v\n", diff.Key, err)
+				os.Exit(1)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			fmt.Printf("Error writing output file: %v\n", err)
+			os.Exit(1)
+		}
+		output.Close()
+	}
+
+	// Print summary
+	counts := make(map[string]int)
+	for _, diff := range diffs {
+		counts[diff.Status]++
+	}
+	fmt.Printf("Compared %d records in %s with %d records in %s (key: %s)\n", len(oldSet.Order), *oldPath, len(newSet.Order), *newPath, *keyPath)
+	fmt.Printf("Added: %d, Removed: %d, Changed: %d, Unchanged: %d\n", counts[statusAdded], counts[statusRemoved], counts[statusChanged], unchangedCount)
+	for _, set := range []struct {
+		name    string
+		records *recordSet
+	}{{"old", oldSet}, {"new", newSet}} {
+		if set.records.Invalid > 0 || set.records.Unkeyed > 0 || set.records.Duplicates > 0 {
+			fmt.Printf("Skipped in %s file: %d invalid lines, %d records without a key, %d duplicate keys (last kept)\n",
+				set.name, set.records.Invalid, set.records.Unkeyed, set.records.Duplicates)
+		}
+	}
+
+	if len(fieldCounts) > 0 && *topFields > 0 {
+		fields := make([]string, 0, len(fieldCounts))
+		for field := range fieldCounts {
+			fields = append(fields, field)
+		}
+		sort.Slice(fields, func(i, j int) bool {
+			if fieldCounts[fields[i]] != fieldCounts[fields[j]] {
+				return fieldCounts[fields[i]] > fieldCounts[fields[j]]
+			}
+			return fields[i] < fields[j]
+		})
+		if len(fields) > *topFields {
+			fields = fields[:*topFields]
+		}
+		parts := make([]string, len(fields))
+		for i, field := range fields {
+			parts[i] = fmt.Sprintf("%s (%d)", field, fieldCounts[field])
+		}
+		fmt.Printf("Most changed fields: %s\n", strings.Join(parts, ", "))
+	}
+	if *outputPath != "" {
+		fmt.Printf("Per-record diff written to %s\n", *outputPath)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/discovery"
+)
+
+// checkResult records the outcome of a single preflight check
+type checkResult struct {
+	Name    string
+	Passed  bool
+	Details string
+}
+
+// Validate that every line of a JSONL file is a JSON object, as jsonl-splitter expects
+func checkJSONL(path string) checkResult {
+	name := fmt.Sprintf("JSONL input %s", path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return checkResult{name, false, err.Error()}
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineCount := 0
+	recordCount := 0
+	var badLines []string
+	for scanner.Scan() {
+		lineCount++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			badLines = append(badLines, fmt.Sprintf("line %d: %v", lineCount, err))
+			continue
+		}
+		recordCount++
+	}
+	if err := scanner.Err(); err != nil {
+		return checkResult{name, false, fmt.Sprintf("error reading file: %v", err)}
+	}
+
+	if len(badLines) > 0 {
+		details := fmt.Sprintf("%d of %d lines failed to parse", len(badLines), lineCount)
+		if len(badLines) <= 5 {
+			details += " (" + strings.Join(badLines, "; ") + ")"
+		} else {
+			details += " (first: " + strings.Join(badLines[:5], "; ") + ")"
+		}
+		return checkResult{name, false, details}
+	}
+	return checkResult{name, true, fmt.Sprintf("%d valid records", recordCount)}
+}
+
+// Confirm process-linkedin-profiles will find JSON or markdown files in its input folder
+func checkInputFolder(folder string) checkResult {
+	name := fmt.Sprintf("Profile input folder %s", folder)
+
+	info, err := os.Stat(folder)
+	if err != nil {
+		return checkResult{name, false, err.Error()}
+	}
+	if !info.IsDir() {
+		return checkResult{name, false, "not a directory"}
+	}
+
+	count := 0
+	if err := discovery.FindInputFiles(folder, func(string) { count++ }); err != nil {
+		return checkResult{name, false, err.Error()}
+	}
+	if count == 0 {
+		return checkResult{name, false, "no JSON or markdown files found"}
+	}
+	return checkResult{name, true, fmt.Sprintf("%d files to process", count)}
+}
+
+// Confirm the fabric binary resolves on PATH
+func checkFabric() checkResult {
+	path, err := exec.LookPath("fabric")
+	if err != nil {
+		return checkResult{"fabric binary", false, err.Error()}
+	}
+	return checkResult{"fabric binary", true, path}
+}
+
+// Confirm a directory is writable, or can be created under its nearest existing parent,
+// without leaving anything behind
+func checkWritableDir(dir string) checkResult {
+	name := fmt.Sprintf("Output directory %s", dir)
+
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return checkResult{name, false, fmt.Sprintf("%s is not a directory", existing)}
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return checkResult{name, false, err.Error()}
+		}
+		existing = parent
+	}
+
+	probe, err := os.CreateTemp(existing, ".preflight-*")
+	if err != nil {
+		return checkResult{name, false, fmt.Sprintf("not writable: %v", err)}
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if existing != dir {
+		return checkResult{name, true, fmt.Sprintf("will be created under %s", existing)}
+	}
+	return checkResult{name, true, "writable"}
+}
+
+// Confirm a CSV file has a header row containing the expected columns
+func checkCSVColumns(path string, expected []string) checkResult {
+	name := fmt.Sprintf("CSV file %s", path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return checkResult{name, false, err.Error()}
+	}
+	defer file.Close()
+
+	headers, err := csv.NewReader(file).Read()
+	if err != nil {
+		return checkResult{name, false, fmt.Sprintf("error reading header: %v", err)}
+	}
+
+	present := make(map[string]bool)
+	for _, header := range headers {
+		present[header] = true
+	}
+	var missing []string
+	for _, column := range expected {
+		if !present[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return checkResult{name, false, fmt.Sprintf("missing columns: %s", strings.Join(missing, ", "))}
+	}
+	return checkResult{name, true, fmt.Sprintf("%d columns", len(headers))}
+}
+
+// Split a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func main() {
+	// Define command-line flags
+	jsonlPath := flag.String("jsonl", "", "JSONL file to validate before splitting")
+	inputFolder := flag.String("input", "data/test/split", "Folder that process-linkedin-profiles will read")
+	skipFabric := flag.Bool("skip-fabric", false, "Skip checking that the fabric binary resolves")
+	outputDirs := flag.String("dirs", "data/test/split,data/test/profile,logs", "Comma-separated output directories that must be writable")
+	csvPath := flag.String("csv", "", "CSV file the attachers will enrich")
+	columns := flag.String("columns", "", "Comma-separated columns the CSV must contain")
+	flag.Parse()
+
+	var results []checkResult
+	if *jsonlPath != "" {
+		results = append(results, checkJSONL(*jsonlPath))
+	}
+	if *inputFolder != "" {
+		results = append(results, checkInputFolder(*inputFolder))
+	}
+	if !*skipFabric {
+		results = append(results, checkFabric())
+	}
+	for _, dir := range splitList(*outputDirs) {
+		results = append(results, checkWritableDir(dir))
+	}
+	if *csvPath != "" {
+		results = append(results, checkCSVColumns(*csvPath, splitList(*columns)))
+	}
+
+	// Report each check
+	failedCount := 0
+	for _, result := range results {
+		status := "OK"
+		if !result.Passed {
+			status = "FAIL"
+			failedCount++
+		}
+		fmt.Printf("%-4s %s: %s\n", status, result.Name, result.Details)
+	}
+
+	fmt.Printf("Preflight summary: %d checks, %d passed, %d failed\n", len(results), len(results)-failedCount, failedCount)
+	if failedCount > 0 {
+		os.Exit(1)
+	}
+}
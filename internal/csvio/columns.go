@@ -0,0 +1,45 @@
+package csvio
+
+import "strings"
+
+// StripEmptyColumns drops every column whose data cells are all blank and returns the
+// projected records with the names of the removed columns. A CSV without data rows keeps
+// all of its columns, since there is nothing to show they are unused.
+func StripEmptyColumns(records [][]string) ([][]string, []string) {
+	if len(records) < 2 {
+		return records, nil
+	}
+	headers := records[0]
+	var keep []int
+	var removed []string
+	for j, header := range headers {
+		empty := true
+		for i := 1; i < len(records); i++ {
+			if j < len(records[i]) && strings.TrimSpace(records[i][j]) != "" {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			removed = append(removed, header)
+		} else {
+			keep = append(keep, j)
+		}
+	}
+	if len(removed) == 0 {
+		return records, nil
+	}
+
+	projected := make([][]string, len(records))
+	for i, row := range records {
+		projected[i] = make([]string, 0, len(keep))
+		for _, j := range keep {
+			if j < len(row) {
+				projected[i] = append(projected[i], row[j])
+			} else {
+				projected[i] = append(projected[i], "")
+			}
+		}
+	}
+	return projected, removed
+}
@@ -0,0 +1,16 @@
+package csvio
+
+import "fmt"
+
+// ParseComment checks a -comment value and returns the character that starts a skipped
+// line, or 0 when the value is empty and no lines are skipped
+func ParseComment(value string) (rune, error) {
+	if value == "" {
+		return 0, nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == ',' || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' {
+		return 0, fmt.Errorf("expected a single character other than a comma, quote or newline, got '%s'", value)
+	}
+	return runes[0], nil
+}
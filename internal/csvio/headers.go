@@ -0,0 +1,68 @@
+package csvio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HeaderRename maps an existing column name to the name written in the output
+type HeaderRename struct {
+	From string
+	To   string
+}
+
+// ParseHeaderMap parses an -header-map spec such as "old1=new1,old2=new2"
+func ParseHeaderMap(spec string) ([]HeaderRename, error) {
+	var renames []HeaderRename
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("expected old=new, got '%s'", pair)
+		}
+		renames = append(renames, HeaderRename{From: from, To: to})
+	}
+	return renames, nil
+}
+
+// ApplyHeaderMap renames columns in the header row, finding each one with matches. Every
+// renamed column must exist, and no two columns may end up with the same name.
+func ApplyHeaderMap(headers []string, renames []HeaderRename, matches func(header, columnName string) bool) error {
+	renamed := make(map[int]string)
+	for _, rename := range renames {
+		index := -1
+		for i, header := range headers {
+			if matches(header, rename.From) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return fmt.Errorf("column '%s' not found in CSV header", rename.From)
+		}
+		if _, exists := renamed[index]; exists {
+			return fmt.Errorf("column '%s' is renamed more than once", rename.From)
+		}
+		renamed[index] = rename.To
+	}
+
+	// Check the final header row for collisions before changing anything
+	seen := make(map[string]bool)
+	for i, header := range headers {
+		if to, ok := renamed[i]; ok {
+			header = to
+		}
+		if seen[header] {
+			return fmt.Errorf("renaming would produce duplicate column '%s'", header)
+		}
+		seen[header] = true
+	}
+
+	for i, to := range renamed {
+		headers[i] = to
+	}
+	return nil
+}
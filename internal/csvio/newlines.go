@@ -0,0 +1,75 @@
+// Package csvio holds the CSV reading and writing helpers the CSV attachers share.
+package csvio
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Line endings -normalize-newlines can give the line breaks inside cells
+const (
+	NewlinesKeep = ""
+	NewlinesLF   = "lf"
+	NewlinesCRLF = "crlf"
+)
+
+// ParseNewlines checks a -normalize-newlines value
+func ParseNewlines(value string) (string, error) {
+	switch value {
+	case NewlinesKeep, NewlinesLF, NewlinesCRLF:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown line ending '%s' (use lf or crlf)", value)
+}
+
+// NewWriter creates the CSV writer for an output. Records always end in \r\n; the writer also turns a
+// \n inside a cell into \r\n and drops a lone \r, so -normalize-newlines lf writes cells with
+// \n line breaks and leaves the record endings to crlfRecords.
+func NewWriter(output io.Writer, newlines string) *csv.Writer {
+	if newlines == NewlinesLF {
+		return csv.NewWriter(&crlfRecords{output: output})
+	}
+	writer := csv.NewWriter(output)
+	writer.UseCRLF = true // Use Windows-style line endings for better compatibility
+	return writer
+}
+
+// NormalizeNewlines converts every line break in a row's cells, whether \r\n, \n or a lone \r, to \n before the
+// writer gives them their final form. Rows are left alone without -normalize-newlines.
+func NormalizeNewlines(row []string, newlines string) {
+	if newlines == NewlinesKeep {
+		return
+	}
+	for i, cell := range row {
+		if strings.ContainsRune(cell, '\r') {
+			cell = strings.ReplaceAll(cell, "\r\n", "\n")
+			row[i] = strings.ReplaceAll(cell, "\r", "\n")
+		}
+	}
+}
+
+// crlfRecords ends the records of CSV written with \n endings in \r\n, leaving line breaks
+// inside quoted cells as they are
+type crlfRecords struct {
+	output io.Writer
+	quoted bool // Inside a quoted cell; an escaped "" toggles this twice
+}
+
+func (c *crlfRecords) Write(p []byte) (int, error) {
+	converted := make([]byte, 0, len(p)+8)
+	for _, b := range p {
+		switch {
+		case b == '"':
+			c.quoted = !c.quoted
+		case b == '\n' && !c.quoted:
+			converted = append(converted, '\r')
+		}
+		converted = append(converted, b)
+	}
+	if _, err := c.output.Write(converted); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+package csvio
+
+import "log"
+
+// DedupeRows keeps a single data row per non-empty key value (the first occurrence, or the
+// last when keepLast is set) and returns the remaining records and the number removed
+func DedupeRows(records [][]string, keyIndex int, keepLast bool) ([][]string, int) {
+	keyOf := func(row []string) string {
+		if keyIndex < len(row) {
+			return row[keyIndex]
+		}
+		return ""
+	}
+
+	// Decide which row wins for each key
+	winner := make(map[string]int)
+	for i := 1; i < len(records); i++ {
+		key := keyOf(records[i])
+		if key == "" {
+			continue
+		}
+		if _, seen := winner[key]; !seen || keepLast {
+			winner[key] = i
+		}
+	}
+
+	deduped := [][]string{records[0]}
+	for i := 1; i < len(records); i++ {
+		key := keyOf(records[i])
+		if key == "" || winner[key] == i {
+			deduped = append(deduped, records[i])
+		}
+	}
+	return deduped, len(records) - len(deduped)
+}
+
+// NormalizeRowLengths pads or truncates every data row to the header's length and
+// returns how many rows needed adjusting
+func NormalizeRowLengths(records [][]string) int {
+	width := len(records[0])
+	raggedCount := 0
+	for i := 1; i < len(records); i++ {
+		var ragged bool
+		records[i], ragged = NormalizeRowLength(records[i], width, i)
+		if ragged {
+			raggedCount++
+		}
+	}
+	return raggedCount
+}
+
+// NormalizeRowLength pads or truncates one data row to width fields, reporting whether it
+// needed adjusting
+func NormalizeRowLength(row []string, width int, rowNumber int) ([]string, bool) {
+	if len(row) == width {
+		return row, false
+	}
+	if len(row) > width {
+		for _, extra := range row[width:] {
+			if extra != "" {
+				log.Printf("Dropping non-empty extra fields from row %d: %q", rowNumber, row[width:])
+				break
+			}
+		}
+		row = row[:width]
+	}
+	for len(row) < width {
+		row = append(row, "")
+	}
+	return row, true
+}
@@ -0,0 +1,101 @@
+package csvio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+)
+
+// Stream writes rows as they are produced. A file output goes to a temporary file beside
+// it that Commit renames into place, so -stream can overwrite the CSV it is reading.
+type Stream struct {
+	file     *os.File // Temporary file, nil when writing to stdout
+	path     string
+	writer   *csv.Writer
+	newlines string // -normalize-newlines line ending for line breaks in cells
+}
+
+// CreateStream opens a streaming CSV writer for a file path, or stdout for "-"
+func CreateStream(path string, newlines string) (*Stream, error) {
+	stream := &Stream{path: path, newlines: newlines}
+	output := io.Writer(os.Stdout)
+	if path != "-" {
+		file, err := createTemp(path)
+		if err != nil {
+			return nil, fmt.Errorf("creating output CSV file: %w", err)
+		}
+		stream.file = file
+		output = file
+	}
+	stream.writer = NewWriter(output, newlines)
+	return stream, nil
+}
+
+// Create the temporary file for path with the permissions the output should end up with:
+// those of the file it replaces, or the usual 0666 less the umask for a new one.
+// os.CreateTemp always uses 0600, which the rename would carry over to the output.
+func createTemp(path string) (*os.File, error) {
+	perm := os.FileMode(0o666)
+	info, statErr := os.Stat(path)
+	if statErr == nil {
+		perm = info.Mode().Perm()
+	}
+	for attempt := 0; ; attempt++ {
+		name := filepath.Join(filepath.Dir(path), fmt.Sprintf("%s.tmp-%d", filepath.Base(path), rand.Uint32()))
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) && attempt < 100 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The umask applies on creation, so an existing file's mode is set exactly
+		if statErr == nil {
+			if err := file.Chmod(perm); err != nil {
+				file.Close()
+				os.Remove(name)
+				return nil, err
+			}
+		}
+		return file, nil
+	}
+}
+
+// Write writes one row
+func (s *Stream) Write(row []string) error {
+	NormalizeNewlines(row, s.newlines)
+	if err := s.writer.Write(row); err != nil {
+		return fmt.Errorf("writing CSV: %w", err)
+	}
+	return nil
+}
+
+// Commit flushes the rows and moves a file output into place
+func (s *Stream) Commit() error {
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		s.Abort()
+		return fmt.Errorf("flushing CSV writer: %w", err)
+	}
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Close(); err != nil {
+		os.Remove(s.file.Name())
+		return fmt.Errorf("closing output CSV file: %w", err)
+	}
+	return os.Rename(s.file.Name(), s.path)
+}
+
+// Abort discards a partially written file output
+func (s *Stream) Abort() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
@@ -0,0 +1,84 @@
+package csvio
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// WriteCSV writes the records to a CSV file, or to stdout for "-"
+func WriteCSV(path string, records [][]string, newlines string) error {
+	outputFile := os.Stdout
+	if path != "-" {
+		var err error
+		outputFile, err = os.Create(path)
+		if err != nil {
+			return fmt.Errorf("creating output CSV file: %w", err)
+		}
+		defer outputFile.Close()
+	}
+
+	writer := NewWriter(outputFile, newlines)
+
+	// Write all records
+	for _, record := range records {
+		NormalizeNewlines(record, newlines)
+	}
+	if err := writer.WriteAll(records); err != nil {
+		return fmt.Errorf("writing CSV: %w", err)
+	}
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("flushing CSV writer: %w", err)
+	}
+	return nil
+}
+
+// WriteJSONL writes each data row as a JSON object keyed by the header names, in header
+// order, to a file or to stdout for "-"
+func WriteJSONL(path string, records [][]string) error {
+	seen := make(map[string]bool)
+	for _, header := range records[0] {
+		if seen[header] {
+			return fmt.Errorf("duplicate column '%s' can't be used as a JSON key", header)
+		}
+		seen[header] = true
+	}
+
+	outputFile := os.Stdout
+	if path != "-" {
+		var err error
+		outputFile, err = os.Create(path)
+		if err != nil {
+			return fmt.Errorf("creating output JSONL file: %w", err)
+		}
+		defer outputFile.Close()
+	}
+
+	writer := bufio.NewWriter(outputFile)
+	for _, row := range records[1:] {
+		writer.WriteByte('{')
+		for j, header := range records[0] {
+			value := ""
+			if j < len(row) {
+				value = row[j]
+			}
+			if j > 0 {
+				writer.WriteByte(',')
+			}
+			// Strings always encode
+			key, _ := json.Marshal(header)
+			encoded, _ := json.Marshal(value)
+			writer.Write(key)
+			writer.WriteByte(':')
+			writer.Write(encoded)
+		}
+		writer.WriteString("}\n")
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("writing JSONL: %w", err)
+	}
+	return nil
+}
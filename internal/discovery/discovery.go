@@ -0,0 +1,44 @@
+// Package discovery finds the input files process-linkedin-profiles works through, so tools
+// that check an input folder count the same files a run would.
+package discovery
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions lists the input file extensions in the order their files are found
+var Extensions = []string{".json", ".md"}
+
+// FindInputFiles calls found for each input file in a folder as soon as it is discovered:
+// the JSON files first and then the markdown files, each in lexical order. Subdirectories
+// are not descended into, and a missing folder simply has no files.
+func FindInputFiles(folder string, found func(filePath string)) error {
+	if _, err := os.Stat(folder); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	for _, ext := range Extensions {
+		err := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if path == folder {
+					return nil
+				}
+				return filepath.SkipDir
+			}
+			if strings.ToLower(filepath.Ext(path)) == ext {
+				found(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Package dotpath looks up dot-separated field paths such as "profile.publicIdentifier" in
+// decoded JSON records, for the tools that name, compare or rewrite records by such paths.
+package dotpath
+
+import "strings"
+
+// Split splits a dot-separated path into its object keys
+func Split(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// Lookup follows a path of object keys from a record, reporting false when a step is
+// missing or isn't an object. An empty path yields the record itself.
+func Lookup(record map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = record
+	for _, part := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// LookupObject follows a path of object keys from a record like Lookup, also reporting
+// false when the value at the end of the path isn't an object
+func LookupObject(record map[string]interface{}, path []string) (map[string]interface{}, bool) {
+	value, ok := Lookup(record, path)
+	if !ok {
+		return nil, false
+	}
+	object, ok := value.(map[string]interface{})
+	return object, ok
+}
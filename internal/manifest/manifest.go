@@ -0,0 +1,98 @@
+// Package manifest describes the files written by jsonl-splitter so that
+// process-linkedin-profiles can process only what changed between two splits.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Entry describes one record written by a split
+type Entry struct {
+	PublicIdentifier string `json:"publicIdentifier,omitempty"` // Empty when the record fell back to a generated name
+	File             string `json:"file"`                       // Path relative to the split's output directory
+	SHA256           string `json:"sha256"`                     // Hash of the file's contents
+}
+
+// Key identifies an entry across manifests: its publicIdentifier, or its file when it has none
+func (e Entry) Key() string {
+	if e.PublicIdentifier != "" {
+		return e.PublicIdentifier
+	}
+	return e.File
+}
+
+// Manifest lists the records written by one split of an input file
+type Manifest struct {
+	Input   string    `json:"input"`
+	Created time.Time `json:"created"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Hash returns the hex-encoded SHA-256 of data, as stored in Entry.SHA256
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Load reads a manifest file
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest file %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Save writes the manifest atomically so a reader never sees a partial file
+func Save(path string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Changed returns the entries of current that are new or whose contents differ from prior.
+// A nil prior treats every entry as new.
+func Changed(prior, current *Manifest) []Entry {
+	previous := make(map[string]string)
+	if prior != nil {
+		for _, entry := range prior.Entries {
+			previous[entry.Key()] = entry.SHA256
+		}
+	}
+
+	var changed []Entry
+	for _, entry := range current.Entries {
+		if hash, exists := previous[entry.Key()]; exists && hash == entry.SHA256 {
+			continue
+		}
+		changed = append(changed, entry)
+	}
+	return changed
+}
@@ -0,0 +1,268 @@
+// Package matcher provides the strategies the CSV attachers use to decide whether a
+// CSV field refers to a given markdown file's base name.
+package matcher
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Strategy names accepted by New
+const (
+	StrategyContains = "contains"
+	StrategyExact    = "exact"
+	StrategyRegex    = "regex"
+	StrategyURL      = "url"
+	StrategyLeaf     = "leaf"
+	StrategyFuzzy    = "fuzzy"
+)
+
+// Matcher reports whether a CSV field matches a markdown file's base name
+type Matcher interface {
+	Match(field, baseName string) bool
+}
+
+// ContainsMatcher matches when the field contains the base name anywhere
+type ContainsMatcher struct{}
+
+func (ContainsMatcher) Match(field, baseName string) bool {
+	return strings.Contains(field, baseName)
+}
+
+// ExactMatcher matches when the field equals the base name
+type ExactMatcher struct{}
+
+func (ExactMatcher) Match(field, baseName string) bool {
+	return field == baseName
+}
+
+// RegexMatcher extracts an identifier from the field with a regular expression (the first
+// capture group, or the whole match when the pattern has no groups) and matches when it
+// equals the base name
+type RegexMatcher struct {
+	Pattern *regexp.Regexp
+}
+
+func (m RegexMatcher) Match(field, baseName string) bool {
+	identifier, ok := m.Identifier(field)
+	return ok && identifier == baseName
+}
+
+// NormalizedURLMatcher treats the field as a profile URL and matches when its final path
+// segment equals the base name, ignoring scheme, host, query, fragment, trailing slashes,
+// percent-encoding and case (e.g. "https://www.linkedin.com/in/John-Smith/?trk=x" matches "john-smith")
+type NormalizedURLMatcher struct{}
+
+func (m NormalizedURLMatcher) Match(field, baseName string) bool {
+	identifier, ok := m.Identifier(field)
+	return ok && identifier == m.IndexKey(baseName)
+}
+
+// normalizeURLIdentifier returns the lowercased, unescaped final path segment of a URL-like value
+func normalizeURLIdentifier(value string) string {
+	value = strings.TrimSpace(value)
+	if i := strings.IndexAny(value, "?#"); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.TrimRight(value, "/")
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		value = value[i+1:]
+	}
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		value = unescaped
+	}
+	return strings.ToLower(value)
+}
+
+// LeafMatcher treats the field as a hierarchical identifier and matches when its last
+// "/"-separated segment equals the base name (e.g. "acme/john-smith" matches "john-smith")
+type LeafMatcher struct{}
+
+func (m LeafMatcher) Match(field, baseName string) bool {
+	leaf, ok := m.Identifier(field)
+	return ok && leaf == baseName
+}
+
+// trimToLeaf returns the last "/"-separated segment of a value, ignoring trailing slashes
+func trimToLeaf(field string) string {
+	field = strings.TrimRight(field, "/")
+	if i := strings.LastIndex(field, "/"); i >= 0 {
+		return field[i+1:]
+	}
+	return field
+}
+
+// FuzzyMatcher matches when the field is within MaxDistance single-character edits
+// (Levenshtein distance) of the base name, e.g. "john-smyth" matches "john-smith" at distance
+// 1. Matches that aren't exact are logged so they can be audited.
+type FuzzyMatcher struct {
+	MaxDistance int
+}
+
+func (m FuzzyMatcher) Match(field, baseName string) bool {
+	if field == baseName {
+		return true
+	}
+	distance, ok := boundedDistance(field, baseName, m.MaxDistance)
+	if ok {
+		log.Printf("Fuzzy match: '%s' ~ '%s' (distance %d)", field, baseName, distance)
+	}
+	return ok
+}
+
+// boundedDistance returns the Levenshtein distance between a and b if it is at most max.
+// Values whose lengths differ by more than max can't be that close and are rejected before
+// any comparison, which keeps long fields cheap to rule out.
+func boundedDistance(a, b string, max int) (int, bool) {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return 0, false
+	}
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		rowMin := current[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			rowMin = min(rowMin, current[j])
+		}
+		// Every later row is at least this row's minimum
+		if rowMin > max {
+			return 0, false
+		}
+		previous, current = current, previous
+	}
+	distance := previous[len(rb)]
+	return distance, distance <= max
+}
+
+// TrimMatcher trims leading/trailing whitespace from both values before delegating
+type TrimMatcher struct {
+	Matcher Matcher
+}
+
+func (m TrimMatcher) Match(field, baseName string) bool {
+	return m.Matcher.Match(strings.TrimSpace(field), strings.TrimSpace(baseName))
+}
+
+// Indexer is implemented for matchers whose fields refer to a single identifier, so base
+// names can be indexed up front and each field looked up directly instead of being
+// compared with every name
+type Indexer interface {
+	// Identifier returns the index key a field refers to, or false if it refers to none
+	Identifier(field string) (string, bool)
+	// IndexKey returns the key a base name is indexed under
+	IndexKey(baseName string) string
+}
+
+func (ExactMatcher) Identifier(field string) (string, bool) {
+	return field, field != ""
+}
+
+func (ExactMatcher) IndexKey(baseName string) string {
+	return baseName
+}
+
+func (m RegexMatcher) Identifier(field string) (string, bool) {
+	submatches := m.Pattern.FindStringSubmatch(field)
+	if submatches == nil {
+		return "", false
+	}
+	if len(submatches) > 1 {
+		return submatches[1], true
+	}
+	return submatches[0], true
+}
+
+func (RegexMatcher) IndexKey(baseName string) string {
+	return baseName
+}
+
+func (NormalizedURLMatcher) Identifier(field string) (string, bool) {
+	identifier := normalizeURLIdentifier(field)
+	return identifier, identifier != ""
+}
+
+func (NormalizedURLMatcher) IndexKey(baseName string) string {
+	return strings.ToLower(baseName)
+}
+
+func (LeafMatcher) Identifier(field string) (string, bool) {
+	leaf := trimToLeaf(field)
+	return leaf, leaf != ""
+}
+
+func (LeafMatcher) IndexKey(baseName string) string {
+	return baseName
+}
+
+// trimIndexer trims whitespace from both values before delegating, like TrimMatcher
+type trimIndexer struct {
+	Indexer Indexer
+}
+
+func (t trimIndexer) Identifier(field string) (string, bool) {
+	return t.Indexer.Identifier(strings.TrimSpace(field))
+}
+
+func (t trimIndexer) IndexKey(baseName string) string {
+	return t.Indexer.IndexKey(strings.TrimSpace(baseName))
+}
+
+// IndexerFor returns the Indexer for a matcher, or false when the strategy can't be
+// indexed (contains matching compares every name against every field)
+func IndexerFor(m Matcher) (Indexer, bool) {
+	if trim, ok := m.(TrimMatcher); ok {
+		inner, ok := IndexerFor(trim.Matcher)
+		if !ok {
+			return nil, false
+		}
+		return trimIndexer{Indexer: inner}, true
+	}
+	indexer, ok := m.(Indexer)
+	return indexer, ok
+}
+
+// New returns the matcher for a strategy name. The pattern is only used by the regex strategy
+// and maxDistance only by the fuzzy strategy.
+func New(strategy string, pattern string, maxDistance int) (Matcher, error) {
+	switch strategy {
+	case StrategyContains, "":
+		return ContainsMatcher{}, nil
+	case StrategyExact:
+		return ExactMatcher{}, nil
+	case StrategyRegex:
+		if pattern == "" {
+			return nil, fmt.Errorf("the %s match strategy requires a pattern", StrategyRegex)
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match pattern: %w", err)
+		}
+		return RegexMatcher{Pattern: compiled}, nil
+	case StrategyURL:
+		return NormalizedURLMatcher{}, nil
+	case StrategyLeaf:
+		return LeafMatcher{}, nil
+	case StrategyFuzzy:
+		if maxDistance < 0 {
+			return nil, fmt.Errorf("the %s match strategy requires a non-negative maximum distance", StrategyFuzzy)
+		}
+		return FuzzyMatcher{MaxDistance: maxDistance}, nil
+	default:
+		return nil, fmt.Errorf("unknown match strategy '%s' (use %s, %s, %s, %s, %s or %s)",
+			strategy, StrategyContains, StrategyExact, StrategyRegex, StrategyURL, StrategyLeaf, StrategyFuzzy)
+	}
+}
@@ -0,0 +1,179 @@
+package matcher
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		strategy    string
+		pattern     string
+		maxDistance int
+		want        Matcher
+		wantErr     bool
+	}{
+		{name: "default", strategy: "", want: ContainsMatcher{}},
+		{name: "contains", strategy: StrategyContains, want: ContainsMatcher{}},
+		{name: "exact", strategy: StrategyExact, want: ExactMatcher{}},
+		{name: "regex", strategy: StrategyRegex, pattern: `in/([^/]+)`, want: RegexMatcher{Pattern: regexp.MustCompile(`in/([^/]+)`)}},
+		{name: "regex without pattern", strategy: StrategyRegex, wantErr: true},
+		{name: "regex with invalid pattern", strategy: StrategyRegex, pattern: `(`, wantErr: true},
+		{name: "url", strategy: StrategyURL, want: NormalizedURLMatcher{}},
+		{name: "leaf", strategy: StrategyLeaf, want: LeafMatcher{}},
+		{name: "fuzzy", strategy: StrategyFuzzy, maxDistance: 2, want: FuzzyMatcher{MaxDistance: 2}},
+		{name: "fuzzy with zero distance", strategy: StrategyFuzzy, want: FuzzyMatcher{}},
+		{name: "fuzzy with negative distance", strategy: StrategyFuzzy, maxDistance: -1, wantErr: true},
+		{name: "unknown", strategy: "suffix", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.strategy, tt.pattern, tt.maxDistance)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("New(%q, %q, %d) = %#v, want an error", tt.strategy, tt.pattern, tt.maxDistance, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New(%q, %q, %d) returned error: %v", tt.strategy, tt.pattern, tt.maxDistance, err)
+			}
+			if regex, ok := tt.want.(RegexMatcher); ok {
+				gotRegex, ok := got.(RegexMatcher)
+				if !ok || gotRegex.Pattern.String() != regex.Pattern.String() {
+					t.Fatalf("New(%q, %q, %d) = %#v, want %#v", tt.strategy, tt.pattern, tt.maxDistance, got, tt.want)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("New(%q, %q, %d) = %#v, want %#v", tt.strategy, tt.pattern, tt.maxDistance, got, tt.want)
+			}
+		})
+	}
+}
+
+// matchTests lists fields and base names for each strategy with whether they should match
+var matchTests = []struct {
+	name     string
+	matcher  Matcher
+	field    string
+	baseName string
+	want     bool
+}{
+	{"contains substring", ContainsMatcher{}, "https://www.linkedin.com/in/john-smith/", "john-smith", true},
+	{"contains prefix of longer name", ContainsMatcher{}, "john-smithson", "john-smith", true},
+	{"contains missing", ContainsMatcher{}, "jane-doe", "john-smith", false},
+	{"contains is case-sensitive", ContainsMatcher{}, "John-Smith", "john-smith", false},
+
+	{"exact equal", ExactMatcher{}, "john-smith", "john-smith", true},
+	{"exact longer field", ExactMatcher{}, "john-smithson", "john-smith", false},
+	{"exact is case-sensitive", ExactMatcher{}, "John-Smith", "john-smith", false},
+	{"exact keeps whitespace", ExactMatcher{}, " john-smith", "john-smith", false},
+
+	{"regex capture group", RegexMatcher{Pattern: regexp.MustCompile(`in/([^/?]+)`)}, "https://linkedin.com/in/john-smith?trk=x", "john-smith", true},
+	{"regex whole match", RegexMatcher{Pattern: regexp.MustCompile(`[a-z]+-[a-z]+`)}, "id: john-smith", "john-smith", true},
+	{"regex capture differs", RegexMatcher{Pattern: regexp.MustCompile(`in/([^/?]+)`)}, "https://linkedin.com/in/jane-doe", "john-smith", false},
+	{"regex no match", RegexMatcher{Pattern: regexp.MustCompile(`in/([^/?]+)`)}, "john-smith", "john-smith", false},
+
+	{"url full profile URL", NormalizedURLMatcher{}, "https://www.linkedin.com/in/John-Smith/?trk=x#top", "john-smith", true},
+	{"url ignores base name case", NormalizedURLMatcher{}, "linkedin.com/in/john-smith", "John-Smith", true},
+	{"url unescapes", NormalizedURLMatcher{}, "https://linkedin.com/in/j%C3%B6rg", "jörg", true},
+	{"url bare identifier", NormalizedURLMatcher{}, " john-smith ", "john-smith", true},
+	{"url other profile", NormalizedURLMatcher{}, "https://linkedin.com/in/john-smithson", "john-smith", false},
+	{"url empty field", NormalizedURLMatcher{}, "", "", false},
+
+	{"leaf last segment", LeafMatcher{}, "acme/sales/john-smith", "john-smith", true},
+	{"leaf trailing slash", LeafMatcher{}, "acme/john-smith/", "john-smith", true},
+	{"leaf no separator", LeafMatcher{}, "john-smith", "john-smith", true},
+	{"leaf earlier segment", LeafMatcher{}, "john-smith/notes", "john-smith", false},
+	{"leaf is case-sensitive", LeafMatcher{}, "acme/John-Smith", "john-smith", false},
+
+	{"fuzzy exact", FuzzyMatcher{MaxDistance: 0}, "john-smith", "john-smith", true},
+	{"fuzzy substitution", FuzzyMatcher{MaxDistance: 1}, "john-smyth", "john-smith", true},
+	{"fuzzy insertion", FuzzyMatcher{MaxDistance: 1}, "john-smiith", "john-smith", true},
+	{"fuzzy deletion", FuzzyMatcher{MaxDistance: 1}, "jon-smith", "john-smith", true},
+	{"fuzzy too far", FuzzyMatcher{MaxDistance: 1}, "jon-smyth", "john-smith", false},
+	{"fuzzy within two", FuzzyMatcher{MaxDistance: 2}, "jon-smyth", "john-smith", true},
+	{"fuzzy length difference", FuzzyMatcher{MaxDistance: 2}, "john", "john-smith", false},
+	{"fuzzy counts runes", FuzzyMatcher{MaxDistance: 1}, "jörg", "jorg", true},
+
+	{"trim field and name", TrimMatcher{Matcher: ExactMatcher{}}, "  john-smith\t", " john-smith", true},
+	{"trim delegates", TrimMatcher{Matcher: LeafMatcher{}}, " acme/john-smith ", "john-smith", true},
+	{"trim still compares", TrimMatcher{Matcher: ExactMatcher{}}, " jane-doe ", "john-smith", false},
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matcher.Match(tt.field, tt.baseName); got != tt.want {
+				t.Errorf("%T.Match(%q, %q) = %v, want %v", tt.matcher, tt.field, tt.baseName, got, tt.want)
+			}
+		})
+	}
+}
+
+// An indexed lookup must agree with Match, since -stream and the row index rely on it
+func TestIndexerAgreesWithMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		indexer, ok := IndexerFor(tt.matcher)
+		if !ok {
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			identifier, ok := indexer.Identifier(tt.field)
+			got := ok && identifier == indexer.IndexKey(tt.baseName)
+			if got != tt.want {
+				t.Errorf("indexed lookup of %q for %q = %v, want %v (identifier %q, key %q)",
+					tt.field, tt.baseName, got, tt.want, identifier, indexer.IndexKey(tt.baseName))
+			}
+		})
+	}
+}
+
+func TestIndexerFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher Matcher
+		want    bool
+	}{
+		{"contains", ContainsMatcher{}, false},
+		{"exact", ExactMatcher{}, true},
+		{"regex", RegexMatcher{Pattern: regexp.MustCompile(`x`)}, true},
+		{"url", NormalizedURLMatcher{}, true},
+		{"leaf", LeafMatcher{}, true},
+		{"fuzzy", FuzzyMatcher{MaxDistance: 1}, false},
+		{"trimmed exact", TrimMatcher{Matcher: ExactMatcher{}}, true},
+		{"trimmed contains", TrimMatcher{Matcher: ContainsMatcher{}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := IndexerFor(tt.matcher); got != tt.want {
+				t.Errorf("IndexerFor(%#v) reported %v, want %v", tt.matcher, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoundedDistance(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		max    int
+		want   int
+		wantOK bool
+	}{
+		{"", "", 0, 0, true},
+		{"kitten", "sitting", 3, 3, true},
+		{"kitten", "sitting", 2, 0, false},
+		{"abc", "", 3, 3, true},
+		{"abc", "", 2, 0, false},
+		{"flaw", "lawn", 2, 2, true},
+	}
+	for _, tt := range tests {
+		got, ok := boundedDistance(tt.a, tt.b, tt.max)
+		if ok != tt.wantOK || (ok && got != tt.want) {
+			t.Errorf("boundedDistance(%q, %q, %d) = %d, %v, want %d, %v", tt.a, tt.b, tt.max, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
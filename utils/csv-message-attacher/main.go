@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -10,49 +11,197 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/csvio"
+	"github.com/branexp/linkedin-data-enrichment/internal/matcher"
+)
+
+// Markdown layouts supported for message files
+const (
+	formatLines    = "lines" // Headline on the first line, body on the second, unless sections are labeled
+	formatKeyValue = "kv"    // "key: value" lines, e.g. "subject: ..." and "body: ..."
 )
 
-// readMarkdownFile reads a markdown file and extracts the headline (first line) and body (second line)
-func readMarkdownFile(path string) (string, string, error) {
+// markdownFormat describes how the headline and body are laid out in a message file
+type markdownFormat struct {
+	Mode      string
+	HeadKey   string // Key holding the headline in kv mode
+	BodyKey   string // Key holding the body in kv mode
+	HeadLabel string // Heading (e.g. "# Headline") labeling the headline section in lines mode
+	BodyLabel string // Heading (e.g. "## Body") labeling the body section in lines mode
+}
+
+// readMarkdownFile reads a markdown file and extracts the headline and body according to the format
+func readMarkdownFile(path string, format markdownFormat) (string, string, error) {
+	if format.Mode == formatKeyValue {
+		return readKeyValueMarkdown(path, format.HeadKey, format.BodyKey)
+	}
+	return readLineMarkdown(path, format.HeadLabel, format.BodyLabel)
+}
+
+// readLineMarkdown reads a markdown file and extracts the headline and body. Sections under
+// headings matching the labels (e.g. "# Headline" and "## Body") are extracted in any order;
+// without labeled headings the headline is the first line and the body the second.
+func readLineMarkdown(path string, headLabel string, bodyLabel string) (string, string, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return "", "", fmt.Errorf("error opening markdown file: %w", err)
 	}
 	defer file.Close()
 
+	var lines []string
 	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("error reading markdown: %w", err)
+	}
 
-	// Get the headline (first line)
-	var headline string
-	if scanner.Scan() {
-		headline = scanner.Text()
-	} else {
-		if scanner.Err() != nil {
-			return "", "", fmt.Errorf("error reading headline: %w", scanner.Err())
+	if headline, body, labeled := readLabeledSections(lines, headLabel, bodyLabel); labeled {
+		return headline, body, nil
+	}
+
+	// Fall back to the headline on the first line and the body on the second
+	var headline, body string
+	if len(lines) > 0 {
+		headline = lines[0]
+	}
+	if len(lines) > 1 {
+		body = lines[1]
+	}
+	return headline, body, nil
+}
+
+// readLabeledSections returns the text under the headline and body label headings, matched
+// case-insensitively at any heading level. A section runs until the next labeled heading, so
+// other headings can appear inside the body. It reports false when neither label is present.
+func readLabeledSections(lines []string, headLabel string, bodyLabel string) (string, string, bool) {
+	labelOf := func(line string) string {
+		trimmed := strings.TrimSpace(line)
+		text := strings.TrimLeft(trimmed, "#")
+		if text == trimmed || (text != "" && text[0] != ' ' && text[0] != '\t') {
+			return "" // Not a heading
+		}
+		text = strings.TrimSpace(text)
+		switch {
+		case headLabel != "" && strings.EqualFold(text, headLabel):
+			return headLabel
+		case bodyLabel != "" && strings.EqualFold(text, bodyLabel):
+			return bodyLabel
 		}
-		// Empty file
-		return "", "", nil
+		return ""
 	}
 
-	// Get the body (second line)
-	var body string
-	if scanner.Scan() {
-		body = scanner.Text()
-	} else {
-		if scanner.Err() != nil {
-			return "", "", fmt.Errorf("error reading body: %w", scanner.Err())
+	sections := make(map[string][]string)
+	current := ""
+	labeled := false
+	for _, line := range lines {
+		if label := labelOf(line); label != "" {
+			current = label
+			labeled = true
+			sections[current] = nil
+			continue
+		}
+		if current != "" {
+			sections[current] = append(sections[current], line)
 		}
-		// Only one line in the file
-		return headline, "", nil
 	}
 
-	return headline, body, nil
+	join := func(label string) string {
+		return strings.TrimSpace(strings.Join(sections[label], "\n"))
+	}
+	return join(headLabel), join(bodyLabel), labeled
+}
+
+// readKeyValueMarkdown reads a markdown file of "key: value" lines and returns the values of
+// the headline and body keys (matched case-insensitively). Lines that don't start one of those
+// keys continue the previous value, so a body may span several lines.
+func readKeyValueMarkdown(path string, headKey string, bodyKey string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("error opening markdown file: %w", err)
+	}
+	defer file.Close()
+
+	values := make(map[string][]string)
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Start a new value when the line begins with one of the configured keys
+		if key, value, found := strings.Cut(line, ":"); found {
+			key = strings.ToLower(strings.TrimSpace(key))
+			if key == strings.ToLower(headKey) || key == strings.ToLower(bodyKey) {
+				current = key
+				values[current] = []string{strings.TrimSpace(value)}
+				continue
+			}
+		}
+
+		// Otherwise continue the current value, ignoring anything before the first key
+		if current != "" {
+			values[current] = append(values[current], line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("error reading key/value markdown: %w", err)
+	}
+
+	join := func(key string) string {
+		return strings.TrimSpace(strings.Join(values[strings.ToLower(key)], "\n"))
+	}
+	return join(headKey), join(bodyKey), nil
+}
+
+// attachSpec maps a message filename suffix to the column its content is attached to
+type attachSpec struct {
+	Column string
+	Suffix string
+}
+
+// attachSpecs collects repeated -attach name=suffix flags
+type attachSpecs []attachSpec
+
+func (a *attachSpecs) String() string {
+	var parts []string
+	for _, spec := range *a {
+		parts = append(parts, spec.Column+"="+spec.Suffix)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (a *attachSpecs) Set(value string) error {
+	column, suffix, ok := strings.Cut(value, "=")
+	if !ok || column == "" || suffix == "" {
+		return fmt.Errorf("expected name=suffix, got '%s'", value)
+	}
+	*a = append(*a, attachSpec{Column: column, Suffix: suffix})
+	return nil
+}
+
+// console receives progress and summary output; it is switched to stderr when the
+// CSV itself is written to stdout
+var console io.Writer = os.Stdout
+
+// normalizeHeaders makes header lookups ignore case and surrounding whitespace. It is set
+// by -normalize-headers and never changes the header text that is written out.
+var normalizeHeaders bool
+
+// headerMatches reports whether a CSV header names the given column
+func headerMatches(header, columnName string) bool {
+	if normalizeHeaders {
+		return strings.EqualFold(strings.TrimSpace(header), strings.TrimSpace(columnName))
+	}
+	return header == columnName
 }
 
 // findHeaderIndex finds the index of a header in a CSV header row, or adds it if not found
 func findHeaderIndex(headers []string, columnName string) (int, []string, bool) {
 	for i, header := range headers {
-		if header == columnName {
+		if headerMatches(header, columnName) {
 			return i, headers, false
 		}
 	}
@@ -60,12 +209,13 @@ func findHeaderIndex(headers []string, columnName string) (int, []string, bool)
 	return len(headers), append(headers, columnName), true
 }
 
-// findMatchingMarkdown searches for a markdown file that matches one of the CSV field values
-func findMatchingMarkdown(messageDir string, csvRow []string, verbose bool) (string, bool) {
+// findMatchingMarkdown searches for a markdown file that matches one of the CSV field values.
+// Only filenames ending in suffix (before .md) are considered, and the suffix is stripped
+// before matching.
+func findMatchingMarkdown(messageDir string, suffix string, csvRow []string, m matcher.Matcher, verbose bool) (string, bool, error) {
 	files, err := os.ReadDir(messageDir)
 	if err != nil {
-		log.Printf("Error reading message directory: %v", err)
-		return "", false
+		return "", false, fmt.Errorf("reading message directory: %w", err)
 	}
 
 	for _, file := range files {
@@ -73,33 +223,431 @@ func findMatchingMarkdown(messageDir string, csvRow []string, verbose bool) (str
 			continue
 		}
 
-		// Get the filename without extension for matching
+		// Get the filename without extension or suffix for matching
 		baseFilename := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		if !strings.HasSuffix(baseFilename, suffix) {
+			continue
+		}
+		baseFilename = strings.TrimSuffix(baseFilename, suffix)
 
 		// Check if this filename matches any field in the CSV row
 		for _, field := range csvRow {
-			if strings.Contains(field, baseFilename) {
+			if m.Match(field, baseFilename) {
 				if verbose {
 					log.Printf("Found matching markdown file for %s: %s", field, file.Name())
 				}
-				return filepath.Join(messageDir, file.Name()), true
+				return filepath.Join(messageDir, file.Name()), true, nil
 			}
 		}
 	}
 
-	return "", false
+	return "", false, nil
+}
+
+// messageAttacher fills the message columns of one row at a time, so the same logic serves
+// whole-file and -stream runs
+type messageAttacher struct {
+	MessageDir   string
+	Specs        attachSpecs // Suffixed message columns from -attach; empty attaches headline and body
+	HeadColumn   string
+	BodyColumn   string
+	Format       markdownFormat
+	Matcher      matcher.Matcher
+	Verbose      bool
+	Strict       bool // Fail on the first unreadable message file or directory instead of skipping it
+	ValidateJSON bool // Only attach values that are valid JSON (-validate-json); empty values are left alone
+
+	MergeInto     string             // Column the message columns are merged into; empty disables merging
+	MergeTemplate *template.Template // Layout of the merged value, from -merge-template
+	MergeDrop     bool               // Leave the message columns out of the output once merged
+
+	indices          []int // Column per spec, or the headline and body columns
+	width            int
+	headers          []string // Full header row, naming the merge template's fields
+	mergeIndex       int
+	keep             []int // Columns written with MergeDrop; nil writes every column
+	Attached         int   // Headline and body counts
+	NotFound         int
+	AttachedByColumn map[string]int // Per-column counts for -attach
+	NotFoundByColumn map[string]int
+	InvalidJSON      int // Message files not attached because -validate-json rejected them
+}
+
+// validJSON applies -validate-json to the values read from a message file, counting and
+// reporting a file with an invalid value, or failing the run with Strict
+func (a *messageAttacher) validJSON(mdPath string, values ...string) (bool, error) {
+	if !a.ValidateJSON {
+		return true, nil
+	}
+	for _, value := range values {
+		if value != "" && !json.Valid([]byte(value)) {
+			if a.Strict {
+				return false, fmt.Errorf("markdown file %s is not valid JSON", mdPath)
+			}
+			fmt.Fprintf(console, "Invalid JSON in %s; not attached\n", filepath.Base(mdPath))
+			a.InvalidJSON++
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// prepareHeader finds or adds the message columns, and the merge column, and returns the
+// header row to write
+func (a *messageAttacher) prepareHeader(headers []string) ([]string, error) {
+	columns := []string{a.HeadColumn, a.BodyColumn}
+	if len(a.Specs) > 0 {
+		columns = columns[:0]
+		for _, spec := range a.Specs {
+			columns = append(columns, spec.Column)
+		}
+	}
+	a.AttachedByColumn = make(map[string]int)
+	a.NotFoundByColumn = make(map[string]int)
+
+	a.indices = make([]int, len(columns))
+	for k, column := range columns {
+		var added bool
+		a.indices[k], headers, added = findHeaderIndex(headers, column)
+		if added {
+			log.Printf("Added new column '%s' at index %d", column, a.indices[k])
+		} else {
+			log.Printf("Found existing column '%s' at index %d", column, a.indices[k])
+		}
+	}
+	if a.MergeInto == "" {
+		a.width = len(headers)
+		return headers, nil
+	}
+
+	// The merge column comes after the message columns; a dry run against blank values
+	// catches template fields that aren't columns before any row is written
+	var added bool
+	a.mergeIndex, headers, added = findHeaderIndex(headers, a.MergeInto)
+	if added {
+		log.Printf("Added new column '%s' at index %d", a.MergeInto, a.mergeIndex)
+	} else {
+		log.Printf("Found existing column '%s' at index %d", a.MergeInto, a.mergeIndex)
+	}
+	for _, i := range a.indices {
+		if i == a.mergeIndex {
+			return headers, fmt.Errorf("-merge-into column '%s' is one of the message columns", a.MergeInto)
+		}
+	}
+	a.width = len(headers)
+	a.headers = headers
+	if _, err := a.renderMerge(make([]string, a.width)); err != nil {
+		return headers, err
+	}
+	if !a.MergeDrop {
+		return headers, nil
+	}
+
+	dropped := make(map[int]bool, len(a.indices))
+	for _, i := range a.indices {
+		dropped[i] = true
+	}
+	a.keep = nil
+	var kept []string
+	for i, header := range headers {
+		if !dropped[i] {
+			a.keep = append(a.keep, i)
+			kept = append(kept, header)
+		}
+	}
+	return kept, nil
+}
+
+// enrichRow fills a data row's message columns, then merges them when MergeInto is set
+func (a *messageAttacher) enrichRow(row []string, rowNumber int) ([]string, error) {
+	row, err := a.fillRow(row, rowNumber)
+	if err != nil || a.MergeInto == "" {
+		return row, err
+	}
+	return a.mergeRow(row)
+}
+
+// fillRow pads a data row to the header's width and fills its message columns. Message
+// files that can't be read are logged and skipped, unless Strict is set.
+func (a *messageAttacher) fillRow(row []string, rowNumber int) ([]string, error) {
+	// Ensure the row has enough columns
+	for len(row) < a.width {
+		row = append(row, "")
+	}
+
+	// Attach the whole content of each contact's suffixed message files, e.g.
+	// alice_subject.md and alice_intro.md
+	if len(a.Specs) > 0 {
+		for k, spec := range a.Specs {
+			mdPath, found, err := findMatchingMarkdown(a.MessageDir, spec.Suffix, row, a.Matcher, a.Verbose)
+			if err != nil {
+				if a.Strict {
+					return row, err
+				}
+				log.Printf("Error %v", err)
+			}
+			if !found {
+				log.Printf("No matching %s markdown file found for row %d", spec.Suffix, rowNumber)
+				a.NotFoundByColumn[spec.Column]++
+				continue
+			}
+
+			content, err := os.ReadFile(mdPath)
+			if err != nil {
+				if a.Strict {
+					return row, fmt.Errorf("reading markdown file %s: %w", mdPath, err)
+				}
+				log.Printf("Error reading markdown file %s: %v", mdPath, err)
+				a.NotFoundByColumn[spec.Column]++
+				continue
+			}
+
+			value := strings.TrimSpace(string(content))
+			if valid, err := a.validJSON(mdPath, value); err != nil {
+				return row, err
+			} else if !valid {
+				a.NotFoundByColumn[spec.Column]++
+				continue
+			}
+
+			row[a.indices[k]] = value
+			fmt.Fprintf(console, "Attached %s from %s\n", spec.Column, filepath.Base(mdPath))
+			a.AttachedByColumn[spec.Column]++
+		}
+		return row, nil
+	}
+
+	// Find matching markdown file
+	mdPath, found, err := findMatchingMarkdown(a.MessageDir, "", row, a.Matcher, a.Verbose)
+	if err != nil {
+		if a.Strict {
+			return row, err
+		}
+		log.Printf("Error %v", err)
+	}
+	if !found {
+		log.Printf("No matching markdown file found for row %d", rowNumber)
+		a.NotFound++
+		return row, nil
+	}
+
+	// Read and parse the markdown file
+	headline, body, err := readMarkdownFile(mdPath, a.Format)
+	if err != nil {
+		if a.Strict {
+			return row, fmt.Errorf("reading markdown file %s: %w", mdPath, err)
+		}
+		log.Printf("Error reading markdown file %s: %v", mdPath, err)
+		a.NotFound++
+		return row, nil
+	}
+
+	if valid, err := a.validJSON(mdPath, headline, body); err != nil {
+		return row, err
+	} else if !valid {
+		a.NotFound++
+		return row, nil
+	}
+
+	// Update the CSV row with headline and body
+	row[a.indices[0]] = headline
+	row[a.indices[1]] = body
+
+	baseFilename := strings.TrimSuffix(filepath.Base(mdPath), filepath.Ext(mdPath))
+	fmt.Fprintf(console, "Attached headline and body for %s\n", baseFilename)
+	a.Attached++
+	return row, nil
+}
+
+// Percentage of rows a message was attached to; every enriched row counts as either
+// attached or not found
+func coveragePercent(attached int, notFound int) float64 {
+	if attached+notFound == 0 {
+		return 0
+	}
+	return 100 * float64(attached) / float64(attached+notFound)
+}
+
+// printSummary prints the counts of a message attachment run
+func printSummary(a *messageAttacher, dedupe bool, duplicateCount int) {
+	fmt.Fprintf(console, "CSV update summary:\n")
+	if len(a.Specs) > 0 {
+		for _, spec := range a.Specs {
+			attached, notFound := a.AttachedByColumn[spec.Column], a.NotFoundByColumn[spec.Column]
+			fmt.Fprintf(console, "Messages attached to '%s': %d\n", spec.Column, attached)
+			fmt.Fprintf(console, "Messages not found for '%s': %d\n", spec.Column, notFound)
+			fmt.Fprintf(console, "Coverage of '%s': %.1f%% (%d of %d rows)\n", spec.Column, coveragePercent(attached, notFound), attached, attached+notFound)
+		}
+	} else {
+		fmt.Fprintf(console, "Messages attached: %d\n", a.Attached)
+		fmt.Fprintf(console, "Messages not found: %d\n", a.NotFound)
+		fmt.Fprintf(console, "Coverage: %.1f%% (%d of %d rows)\n", coveragePercent(a.Attached, a.NotFound), a.Attached, a.Attached+a.NotFound)
+	}
+	if a.ValidateJSON {
+		fmt.Fprintf(console, "Messages failing JSON validation: %d\n", a.InvalidJSON)
+	}
+	if dedupe {
+		fmt.Fprintf(console, "Duplicate rows removed: %d\n", duplicateCount)
+	}
+}
+
+// checkCoverage exits non-zero when -min-coverage is set and the run, or any -attach column,
+// fell short of it
+func checkCoverage(a *messageAttacher, minCoverage float64) {
+	if minCoverage <= 0 {
+		return
+	}
+	failed := false
+	if len(a.Specs) > 0 {
+		for _, spec := range a.Specs {
+			if coverage := coveragePercent(a.AttachedByColumn[spec.Column], a.NotFoundByColumn[spec.Column]); coverage < minCoverage {
+				fmt.Fprintf(console, "Error: coverage of '%s' %.1f%% is below -min-coverage %g%%\n", spec.Column, coverage, minCoverage)
+				failed = true
+			}
+		}
+	} else if coverage := coveragePercent(a.Attached, a.NotFound); coverage < minCoverage {
+		fmt.Fprintf(console, "Error: coverage %.1f%% is below -min-coverage %g%%\n", coverage, minCoverage)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
 func main() {
 	// Define command-line flags
 	csvPath := flag.String("csv", "data/test/csv/data.csv", "Path to the CSV file")
 	messageDir := flag.String("messages", "data/test/message", "Directory containing markdown messages")
-	outputCSV := flag.String("output", "", "Output CSV file path (defaults to overwriting input CSV)")
+	outputCSV := flag.String("output", "", "Output CSV file path, or - for stdout (defaults to overwriting input CSV)")
 	headColumnName := flag.String("head", "headline", "Name of the headline column to add/update")
 	bodyColumnName := flag.String("body", "body", "Name of the body column to add/update")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging")
+	trim := flag.Bool("trim", false, "Trim leading/trailing whitespace from CSV fields and filenames before matching")
+	matchStrategy := flag.String("match", matcher.StrategyContains, "Matching strategy: contains, exact, regex, url, leaf or fuzzy")
+	maxDistance := flag.Int("max-distance", 1, "Maximum edit distance between a field and a filename for -match fuzzy")
+	matchPattern := flag.String("match-pattern", "", "Regular expression for -match regex; its first capture group (or whole match) must equal the filename")
+	lenient := flag.Bool("lenient", false, "Tolerate rows whose field count differs from the header")
+	mdFormat := flag.String("md-format", formatLines, "Message file layout: lines (headline on line 1, body on line 2) or kv (key: value lines)")
+	headKey := flag.String("head-key", "subject", "Key holding the headline in -md-format kv")
+	bodyKey := flag.String("body-key", "body", "Key holding the body in -md-format kv")
+	headLabel := flag.String("head-label", "Headline", "Heading labeling the headline section in -md-format lines (e.g. '# Headline'); empty disables it")
+	bodyLabel := flag.String("body-label", "Body", "Heading labeling the body section in -md-format lines (e.g. '## Body'); empty disables it")
+	dedupe := flag.Bool("dedupe-rows", false, "Keep only one row per -key-column value after enrichment")
+	keyColumn := flag.String("key-column", "", "Column identifying duplicate rows for -dedupe-rows")
+	dedupeKeep := flag.String("dedupe-keep", "first", "Which duplicate row to keep with -dedupe-rows: first or last")
+	headerMap := flag.String("header-map", "", "Comma-separated old=new pairs renaming columns in the output header")
+	flag.BoolVar(&normalizeHeaders, "normalize-headers", false, "Compare header names case-insensitively, ignoring surrounding whitespace (output headers are unchanged)")
+	outputJSONL := flag.String("output-jsonl", "", "Write the enriched rows as JSONL objects keyed by header to this path (or - for stdout) instead of a CSV file")
+	stripEmpty := flag.Bool("strip-empty-columns", false, "Drop columns whose every data cell is blank before writing")
+	stream := flag.Bool("stream", false, "Read, enrich and write the CSV one row at a time instead of loading it into memory")
+	minCoverage := flag.Float64("min-coverage", 0, "Exit non-zero after writing the output when messages were attached to fewer than this percentage of data rows (0 disables the check)")
+	validateJSON := flag.Bool("validate-json", false, "Only attach message values that are valid JSON; files with an invalid value are reported and counted as not found (or fail the run with -strict)")
+	strict := flag.Bool("strict", false, "Exit with an error on the first message file or directory that can't be read instead of skipping it")
+	normalizeNewlinesTo := flag.String("normalize-newlines", "", "Rewrite every line break inside CSV cells as lf or crlf; records still end in CRLF (by default the writer turns \\n into CRLF and drops a lone \\r)")
+	commentChar := flag.String("comment", "", "Skip CSV lines starting with this character (e.g. #); by default no lines are skipped")
+	var attachments attachSpecs
+	mergeInto := flag.String("merge-into", "", "Also combine the message columns into this column using -merge-template")
+	mergeTemplate := flag.String("merge-template", "", "Template for -merge-into with columns as fields, e.g. '{{.headline}}\\n\\n{{.body}}' (default: the message columns separated by a blank line)")
+	mergeDrop := flag.Bool("merge-drop", false, "Leave the merged message columns out of the output")
+	flag.Var(&attachments, "attach", "Attach the whole content of <id><suffix>.md files to a column, as name=suffix (repeatable; replaces -head/-body)")
 	flag.Parse()
 
+	if *mdFormat != formatLines && *mdFormat != formatKeyValue {
+		fmt.Fprintf(console, "Error: -md-format must be %s or %s, got '%s'\n", formatLines, formatKeyValue, *mdFormat)
+		os.Exit(1)
+	}
+	if *headLabel != "" && strings.EqualFold(*headLabel, *bodyLabel) {
+		fmt.Fprintln(console, "Error: -head-label and -body-label must differ")
+		os.Exit(1)
+	}
+	format := markdownFormat{Mode: *mdFormat, HeadKey: *headKey, BodyKey: *bodyKey, HeadLabel: strings.TrimSpace(*headLabel), BodyLabel: strings.TrimSpace(*bodyLabel)}
+
+	// Build the matcher used to compare CSV fields with message filenames
+	m, err := matcher.New(*matchStrategy, *matchPattern, *maxDistance)
+	if err != nil {
+		fmt.Fprintf(console, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *trim {
+		m = matcher.TrimMatcher{Matcher: m}
+	}
+
+	comment, err := csvio.ParseComment(*commentChar)
+	if err != nil {
+		fmt.Fprintf(console, "Error: -comment: %v\n", err)
+		os.Exit(1)
+	}
+
+	newlines, err := csvio.ParseNewlines(*normalizeNewlinesTo)
+	if err != nil {
+		fmt.Fprintf(console, "Error: -normalize-newlines: %v\n", err)
+		os.Exit(1)
+	}
+	if newlines != csvio.NewlinesKeep && *outputJSONL != "" {
+		fmt.Fprintln(console, "Error: -normalize-newlines applies to CSV output and can't be combined with -output-jsonl")
+		os.Exit(1)
+	}
+
+	renames, err := csvio.ParseHeaderMap(*headerMap)
+	if err != nil {
+		fmt.Fprintf(console, "Error: invalid -header-map: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Merge into one column, by default joining the message columns with a blank line
+	var merge *template.Template
+	if *mergeInto == "" && (*mergeTemplate != "" || *mergeDrop) {
+		fmt.Fprintln(console, "Error: -merge-template and -merge-drop require -merge-into")
+		os.Exit(1)
+	}
+	if *mergeInto != "" {
+		text := *mergeTemplate
+		if text == "" {
+			columns := []string{*headColumnName, *bodyColumnName}
+			if len(attachments) > 0 {
+				columns = columns[:0]
+				for _, spec := range attachments {
+					columns = append(columns, spec.Column)
+				}
+			}
+			text = defaultMergeTemplate(columns)
+		}
+		merge, err = parseMergeTemplate(text)
+		if err != nil {
+			fmt.Fprintf(console, "Error: invalid -merge-template: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *minCoverage < 0 || *minCoverage > 100 {
+		fmt.Fprintf(console, "Error: -min-coverage must be a percentage between 0 and 100, got %g\n", *minCoverage)
+		os.Exit(1)
+	}
+
+	if *stream && *dedupe {
+		fmt.Fprintln(console, "Error: -stream can't be combined with -dedupe-rows")
+		os.Exit(1)
+	}
+	if *stream && *stripEmpty {
+		fmt.Fprintln(console, "Error: -stream can't be combined with -strip-empty-columns")
+		os.Exit(1)
+	}
+	if *stream && *outputJSONL != "" {
+		fmt.Fprintln(console, "Error: -stream can't be combined with -output-jsonl")
+		os.Exit(1)
+	}
+
+	if *dedupe {
+		if *keyColumn == "" {
+			fmt.Fprintln(console, "Error: -dedupe-rows requires -key-column")
+			os.Exit(1)
+		}
+		if *dedupeKeep != "first" && *dedupeKeep != "last" {
+			fmt.Fprintf(console, "Error: -dedupe-keep must be first or last, got '%s'\n", *dedupeKeep)
+			os.Exit(1)
+		}
+	}
+
 	// Configure logging
 	if !*verbose {
 		log.SetOutput(io.Discard)
@@ -112,123 +660,159 @@ func main() {
 	if *outputCSV == "" {
 		*outputCSV = *csvPath
 	}
-	log.Printf("Output will be written to: %s", *outputCSV)
+
+	// Writing the rows to stdout moves progress output to stderr
+	if *outputCSV == "-" || *outputJSONL == "-" {
+		console = os.Stderr
+	}
+	if *outputJSONL != "" {
+		log.Printf("Output will be written as JSONL to: %s", *outputJSONL)
+	} else {
+		log.Printf("Output will be written to: %s", *outputCSV)
+	}
 
 	// Read the CSV file
 	csvFile, err := os.Open(*csvPath)
 	if err != nil {
-		fmt.Printf("Error opening CSV file: %v\n", err)
+		fmt.Fprintf(console, "Error opening CSV file: %v\n", err)
 		os.Exit(1)
 	}
 	defer csvFile.Close()
 
 	// Parse the CSV
 	reader := csv.NewReader(csvFile)
+	reader.Comment = comment
+	if *lenient {
+		reader.FieldsPerRecord = -1
+	}
+	attacher := &messageAttacher{
+		MessageDir:   *messageDir,
+		Specs:        attachments,
+		HeadColumn:   *headColumnName,
+		BodyColumn:   *bodyColumnName,
+		Format:       format,
+		Matcher:      m,
+		Verbose:      *verbose,
+		Strict:       *strict,
+		ValidateJSON: *validateJSON,
+
+		MergeInto:     *mergeInto,
+		MergeTemplate: merge,
+		MergeDrop:     *mergeDrop,
+	}
+
+	// Stream the rows straight through to the output
+	if *stream {
+		out, err := csvio.CreateStream(*outputCSV, newlines)
+		if err != nil {
+			fmt.Fprintf(console, "Error %v\n", err)
+			os.Exit(1)
+		}
+		rowCount, err := streamMessages(reader, out, attacher, *lenient, renames)
+		if err != nil {
+			out.Abort()
+			fmt.Fprintf(console, "Error %v\n", err)
+			os.Exit(1)
+		}
+		if err := out.Commit(); err != nil {
+			fmt.Fprintf(console, "Error %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("Streamed %d rows", rowCount)
+		printSummary(attacher, false, 0)
+		fmt.Fprintf(console, "Successfully updated CSV with message headlines and bodies at %s\n", *outputCSV)
+		checkCoverage(attacher, *minCoverage)
+		return
+	}
+
 	records, err := reader.ReadAll()
 	if err != nil {
-		fmt.Printf("Error reading CSV: %v\n", err)
+		fmt.Fprintf(console, "Error reading CSV: %v\n", err)
 		os.Exit(1)
 	}
 
 	if len(records) == 0 {
-		fmt.Println("CSV file is empty")
+		fmt.Fprintln(console, "CSV file is empty")
 		os.Exit(1)
 	}
 
 	log.Printf("Read %d rows from CSV file", len(records))
 
-	// Find or add the headline and body columns
-	headers := records[0]
-	headColIndex, headers, headAdded := findHeaderIndex(headers, *headColumnName)
-	bodyColIndex, headers, bodyAdded := findHeaderIndex(headers, *bodyColumnName)
-	records[0] = headers
-
-	if headAdded {
-		log.Printf("Added new column '%s' at index %d", *headColumnName, headColIndex)
-	} else {
-		log.Printf("Found existing column '%s' at index %d", *headColumnName, headColIndex)
-	}
-
-	if bodyAdded {
-		log.Printf("Added new column '%s' at index %d", *bodyColumnName, bodyColIndex)
-	} else {
-		log.Printf("Found existing column '%s' at index %d", *bodyColumnName, bodyColIndex)
-	}
-
-	// Add empty values to all existing rows if needed
-	if headAdded || bodyAdded {
-		for i := 1; i < len(records); i++ {
-			for len(records[i]) < len(headers) {
-				records[i] = append(records[i], "")
-			}
+	// Bring ragged rows in line with the header
+	if *lenient {
+		raggedCount := csvio.NormalizeRowLengths(records)
+		if raggedCount > 0 {
+			fmt.Fprintf(console, "Normalized %d ragged rows to %d fields\n", raggedCount, len(records[0]))
 		}
 	}
 
-	// Track statistics
-	attachedCount := 0
-	notFoundCount := 0
-
-	// Process each row in the CSV
+	// Fill the message columns of every row
+	records[0], err = attacher.prepareHeader(records[0])
+	if err != nil {
+		fmt.Fprintf(console, "Error: %v\n", err)
+		os.Exit(1)
+	}
 	for i := 1; i < len(records); i++ {
-		// Ensure the row has enough columns
-		for len(records[i]) < len(headers) {
-			records[i] = append(records[i], "")
+		records[i], err = attacher.enrichRow(records[i], i)
+		if err != nil {
+			fmt.Fprintf(console, "Error in row %d (-strict): %v\n", i, err)
+			os.Exit(1)
 		}
+	}
 
-		// Find matching markdown file
-		mdPath, found := findMatchingMarkdown(*messageDir, records[i], *verbose)
-		if !found {
-			log.Printf("No matching markdown file found for row %d", i)
-			notFoundCount++
-			continue
+	// Drop duplicate rows by key
+	duplicateCount := 0
+	if *dedupe {
+		keyIndex := -1
+		for i, header := range records[0] {
+			if headerMatches(header, *keyColumn) {
+				keyIndex = i
+				break
+			}
 		}
-
-		// Read and parse the markdown file
-		headline, body, err := readMarkdownFile(mdPath)
-		if err != nil {
-			log.Printf("Error reading markdown file %s: %v", mdPath, err)
-			notFoundCount++
-			continue
+		if keyIndex == -1 {
+			fmt.Fprintf(console, "Error: key column '%s' not found in CSV header\n", *keyColumn)
+			os.Exit(1)
 		}
-
-		// Update the CSV row with headline and body
-		records[i][headColIndex] = headline
-		records[i][bodyColIndex] = body
-
-		baseFilename := strings.TrimSuffix(filepath.Base(mdPath), filepath.Ext(mdPath))
-		fmt.Printf("Attached headline and body for %s\n", baseFilename)
-		attachedCount++
+		records, duplicateCount = csvio.DedupeRows(records, keyIndex, *dedupeKeep == "last")
+		log.Printf("Removed %d duplicate rows keyed by '%s'", duplicateCount, *keyColumn)
 	}
 
-	// Write the updated CSV
-	outputFile, err := os.Create(*outputCSV)
-	if err != nil {
-		fmt.Printf("Error creating output CSV file: %v\n", err)
-		os.Exit(1)
+	// Rename columns for downstream tooling
+	if len(renames) > 0 {
+		if err := csvio.ApplyHeaderMap(records[0], renames, headerMatches); err != nil {
+			fmt.Fprintf(console, "Error: invalid -header-map: %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("Renamed %d columns", len(renames))
 	}
-	defer outputFile.Close()
-
-	writer := csv.NewWriter(outputFile)
 
-	// Configure the writer to handle CSV fields properly
-	writer.UseCRLF = true // Use Windows-style line endings for better compatibility
-
-	// Write all records
-	err = writer.WriteAll(records)
-	if err != nil {
-		fmt.Printf("Error writing CSV: %v\n", err)
-		os.Exit(1)
+	// Drop columns nothing was written to
+	if *stripEmpty {
+		var strippedColumns []string
+		records, strippedColumns = csvio.StripEmptyColumns(records)
+		if len(strippedColumns) > 0 {
+			fmt.Fprintf(console, "Removed %d empty columns: %s\n", len(strippedColumns), strings.Join(strippedColumns, ", "))
+		}
 	}
-	writer.Flush()
 
-	if err := writer.Error(); err != nil {
-		fmt.Printf("Error flushing CSV writer: %v\n", err)
+	// Write the updated rows as JSONL, or as CSV
+	if *outputJSONL != "" {
+		if err := csvio.WriteJSONL(*outputJSONL, records); err != nil {
+			fmt.Fprintf(console, "Error %v\n", err)
+			os.Exit(1)
+		}
+	} else if err := csvio.WriteCSV(*outputCSV, records, newlines); err != nil {
+		fmt.Fprintf(console, "Error %v\n", err)
 		os.Exit(1)
 	}
 
 	// Print summary
-	fmt.Printf("CSV update summary:\n")
-	fmt.Printf("Messages attached: %d\n", attachedCount)
-	fmt.Printf("Messages not found: %d\n", notFoundCount)
-	fmt.Printf("Successfully updated CSV with message headlines and bodies at %s\n", *outputCSV)
+	printSummary(attacher, *dedupe, duplicateCount)
+	if *outputJSONL != "" {
+		fmt.Fprintf(console, "Successfully wrote %d rows as JSONL to %s\n", len(records)-1, *outputJSONL)
+	} else {
+		fmt.Fprintf(console, "Successfully updated CSV with message headlines and bodies at %s\n", *outputCSV)
+	}
+	checkCoverage(attacher, *minCoverage)
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Parse a -merge-template. Fields are row values keyed by column name, such as
+// {{.headline}} or {{index . "first name"}}, and a literal \n stands for a newline so the
+// template can be passed on one shell line.
+func parseMergeTemplate(text string) (*template.Template, error) {
+	text = strings.ReplaceAll(text, `\n`, "\n")
+	return template.New("merge").Option("missingkey=error").Parse(text)
+}
+
+// Build the default -merge-template: the message columns separated by a blank line
+func defaultMergeTemplate(columns []string) string {
+	fields := make([]string, len(columns))
+	for i, column := range columns {
+		fields[i] = fmt.Sprintf("{{index . %q}}", column)
+	}
+	return strings.Join(fields, `\n\n`)
+}
+
+// Render the merged value of a padded row. Surrounding whitespace is trimmed, so a row
+// without messages merges to an empty cell rather than bare separators.
+func (a *messageAttacher) renderMerge(row []string) (string, error) {
+	values := make(map[string]string, len(a.headers))
+	for i, header := range a.headers {
+		if _, exists := values[header]; !exists {
+			values[header] = row[i]
+		}
+	}
+	var b strings.Builder
+	if err := a.MergeTemplate.Execute(&b, values); err != nil {
+		return "", fmt.Errorf("rendering -merge-template: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// Fill the merge column of a padded row and, with MergeDrop, leave out the message columns
+func (a *messageAttacher) mergeRow(row []string) ([]string, error) {
+	merged, err := a.renderMerge(row)
+	if err != nil {
+		return row, err
+	}
+	row[a.mergeIndex] = merged
+	if a.keep == nil {
+		return row, nil
+	}
+	kept := make([]string, len(a.keep))
+	for k, i := range a.keep {
+		kept[k] = row[i]
+	}
+	return kept, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/csvio"
+)
+
+// streamMessages fills the message columns while copying the CSV row by row, so no more
+// than one row is held in memory. It returns the number of data rows written.
+func streamMessages(reader *csv.Reader, out *csvio.Stream, attacher *messageAttacher, lenient bool, renames []csvio.HeaderRename) (int, error) {
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return 0, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading CSV: %w", err)
+	}
+	width := len(headers)
+	headers, err = attacher.prepareHeader(headers)
+	if err != nil {
+		return 0, err
+	}
+
+	// Write the header under its output names
+	if err := csvio.ApplyHeaderMap(headers, renames, headerMatches); err != nil {
+		return 0, fmt.Errorf("invalid -header-map: %w", err)
+	}
+	if err := out.Write(headers); err != nil {
+		return 0, err
+	}
+
+	rowCount := 0
+	raggedCount := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rowCount, fmt.Errorf("reading CSV: %w", err)
+		}
+		rowCount++
+
+		// Bring ragged rows in line with the header
+		if lenient {
+			var ragged bool
+			if row, ragged = csvio.NormalizeRowLength(row, width, rowCount); ragged {
+				raggedCount++
+			}
+		}
+
+		row, err = attacher.enrichRow(row, rowCount)
+		if err != nil {
+			return rowCount, fmt.Errorf("in row %d (-strict): %w", rowCount, err)
+		}
+		if err := out.Write(row); err != nil {
+			return rowCount, err
+		}
+	}
+	if raggedCount > 0 {
+		fmt.Fprintf(console, "Normalized %d ragged rows to %d fields\n", raggedCount, width)
+	}
+	return rowCount, nil
+}
@@ -2,78 +2,292 @@ package main
 
 import (
 	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/csvio"
+	"github.com/branexp/linkedin-data-enrichment/internal/matcher"
 )
 
-func main() {
-	// Define command-line flags
-	csvPath := flag.String("csv", "data/test/csv/data.csv", "Path to the CSV file")
-	profileDir := flag.String("profiles", "data/test/profile", "Directory containing markdown profiles")
-	outputCSV := flag.String("output", "", "Output CSV file path (defaults to overwriting input CSV)")
-	columnName := flag.String("column", "linkedin_profile_summary", "Name of the column to add/update")
-	verbose := flag.Bool("verbose", false, "Enable verbose logging")
-	flag.Parse()
+// console receives progress and summary output; it is switched to stderr when the
+// CSV itself is written to stdout
+var console io.Writer = os.Stdout
 
-	// Configure logging
-	if !*verbose {
-		log.SetOutput(io.Discard)
+// resolveMatchColumns resolves a comma-separated list of column names to header indices, in order
+func resolveMatchColumns(headers []string, spec string) ([]int, error) {
+	var indices []int
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		index := -1
+		for i, header := range headers {
+			if headerMatches(header, name) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("match column '%s' not found in CSV header", name)
+		}
+		indices = append(indices, index)
 	}
+	return indices, nil
+}
 
-	log.Printf("Processing CSV file: %s", *csvPath)
-	log.Printf("Profile directory: %s", *profileDir)
+// findMatchingField returns the index of the first field in the row that matches the identifier.
+// Only the given columns are checked, in order; a nil list checks every column.
+func findMatchingField(row []string, columns []int, identifier string, m matcher.Matcher) (int, bool) {
+	if columns == nil {
+		for j, field := range row {
+			if m.Match(field, identifier) {
+				return j, true
+			}
+		}
+		return -1, false
+	}
 
-	// If no output path specified, use the input path
-	if *outputCSV == "" {
-		*outputCSV = *csvPath
+	for _, j := range columns {
+		if j < len(row) && m.Match(row[j], identifier) {
+			return j, true
+		}
 	}
-	log.Printf("Output will be written to: %s", *outputCSV)
+	return -1, false
+}
 
-	// Read the CSV file
-	csvFile, err := os.Open(*csvPath)
-	if err != nil {
-		fmt.Printf("Error opening CSV file: %v\n", err)
-		os.Exit(1)
+// rowIndex maps each identifier in the candidate fields to the first row and column
+// holding it, for strategies that can be indexed
+type rowIndex struct {
+	indexer   matcher.Indexer
+	locations map[string][2]int
+}
+
+// indexRows indexes the data rows' candidate fields, scanning rows and then columns in the
+// order findMatchingField would
+func indexRows(records [][]string, columns []int, indexer matcher.Indexer) *rowIndex {
+	index := &rowIndex{indexer: indexer, locations: make(map[string][2]int)}
+	for i := 1; i < len(records); i++ {
+		candidates := columns
+		if candidates == nil {
+			candidates = make([]int, len(records[i]))
+			for j := range records[i] {
+				candidates[j] = j
+			}
+		}
+		for _, j := range candidates {
+			if j >= len(records[i]) {
+				continue
+			}
+			key, ok := indexer.Identifier(records[i][j])
+			if !ok {
+				continue
+			}
+			if _, exists := index.locations[key]; !exists {
+				index.locations[key] = [2]int{i, j}
+			}
+		}
 	}
-	defer csvFile.Close()
+	return index
+}
 
-	// Parse the CSV
-	reader := csv.NewReader(csvFile)
-	records, err := reader.ReadAll()
-	if err != nil {
-		fmt.Printf("Error reading CSV: %v\n", err)
-		os.Exit(1)
+// findMatchingRow returns the first data row matching an identifier and the column that
+// matched, using the row index when there is one and scanning the rows otherwise
+func findMatchingRow(records [][]string, rows *rowIndex, columns []int, identifier string, m matcher.Matcher) (int, int, bool) {
+	if rows != nil {
+		location, found := rows.locations[rows.indexer.IndexKey(identifier)]
+		return location[0], location[1], found
 	}
+	for i := 1; i < len(records); i++ {
+		if j, found := findMatchingField(records[i], columns, identifier, m); found {
+			return i, j, true
+		}
+	}
+	return -1, -1, false
+}
 
-	if len(records) == 0 {
-		fmt.Println("CSV file is empty")
-		os.Exit(1)
+// attachOptions controls how markdown profiles are matched to rows
+type attachOptions struct {
+	ProfileDir    string
+	ProfilesJSON  string // Object mapping identifiers to content, used instead of ProfileDir
+	ProfilesStdin bool   // Read JSONL {identifier, markdown} objects from stdin instead of ProfileDir
+	ColumnName    string
+	MatchColumns  string // Comma-separated candidate columns; empty searches every field
+	Matcher       matcher.Matcher
+	Concat        bool   // Append to the existing cell value instead of replacing it
+	Separator     string // Placed between the existing value and appended content
+	Transform     string // Transform applied to the content before it is written (-transform)
+	WrapWidth     int    // Hard-wrap attached content at this many characters; 0 leaves lines as they are
+	MarkColumn    string // Column recording whether a profile was found for each row; empty disables it
+	MarkFound     string // Value written to MarkColumn for rows with a profile
+	MarkMissing   string // Value written to MarkColumn for rows without one
+	TrimFields    bool   // Trim fields before looking them up in a -mapping
+	Strict        bool   // Fail on the first unreadable profile instead of skipping it
+	ValidateJSON  bool   // Only attach profiles whose content is valid JSON
+}
+
+// attachResult summarizes an enrichment pass over the CSV rows
+type attachResult struct {
+	Attached        int
+	NotFound        int
+	AlreadyAppended int            // Profiles skipped by -concat because their marker was already present
+	InvalidJSON     int            // Profiles not attached because -validate-json rejected them, also counted in NotFound
+	Rows            int            // Data rows in the CSV
+	Covered         int            // Data rows holding a profile afterwards, including already appended ones
+	MatchColumns    []string       // Candidate match columns in order, when restricted
+	MatchedByColumn map[string]int // Matches per candidate column
+}
+
+// coverage returns the percentage of data rows holding a profile; a CSV without data rows
+// has no coverage
+func (r attachResult) coverage() float64 {
+	if r.Rows == 0 {
+		return 0
 	}
+	return 100 * float64(r.Covered) / float64(r.Rows)
+}
 
-	log.Printf("Read %d rows from CSV file", len(records))
+// checkJSON applies -validate-json to the content about to be attached. Invalid content is
+// reported and left out, or fails the run with Strict.
+func checkJSON(opts attachOptions, name string, content string) (bool, error) {
+	if !opts.ValidateJSON || json.Valid([]byte(content)) {
+		return true, nil
+	}
+	if opts.Strict {
+		return false, fmt.Errorf("profile %s is not valid JSON", name)
+	}
+	fmt.Fprintf(console, "Invalid JSON in profile %s; not attached\n", name)
+	return false, nil
+}
+
+// appendWithMarker appends content to an existing cell value behind a marker naming the
+// profile, so appending the same profile again is detected and skipped. The marker is an
+// HTML comment and doesn't show when the markdown is rendered.
+func appendWithMarker(existing, content, profile, separator string) (string, bool) {
+	marker := fmt.Sprintf("<!-- linkedin-profile: %s -->", profile)
+	if strings.Contains(existing, marker) {
+		return existing, false
+	}
+	block := marker + "\n" + content
+	if existing == "" {
+		return block, true
+	}
+	return existing + separator + block, true
+}
+
+// profileEntry is one profile to attach, named by the identifier it is matched on
+type profileEntry struct {
+	Name    string
+	Path    string // Markdown file holding the content, when loaded from a directory
+	Content string // Content given directly, when loaded from -profiles-json
+}
+
+// read returns the profile's content, reading its markdown file if it has one
+func (p profileEntry) read() ([]byte, error) {
+	if p.Path != "" {
+		return os.ReadFile(p.Path)
+	}
+	return []byte(p.Content), nil
+}
+
+// readProfilesJSONL reads a stream of {"identifier": ..., "markdown": ...} objects, as piped
+// in with -profiles-stdin, keeping the stream's order. Objects may span lines.
+func readProfilesJSONL(r io.Reader) ([]profileEntry, error) {
+	var profiles []profileEntry
+	seen := make(map[string]int)
+	decoder := json.NewDecoder(r)
+	for number := 1; ; number++ {
+		var object struct {
+			Identifier string `json:"identifier"`
+			Markdown   string `json:"markdown"`
+		}
+		err := decoder.Decode(&object)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing profile object %d (expected {\"identifier\": ..., \"markdown\": ...}): %w", number, err)
+		}
+		if object.Identifier == "" {
+			return nil, fmt.Errorf("profile object %d has no identifier", number)
+		}
+		if first, exists := seen[object.Identifier]; exists {
+			return nil, fmt.Errorf("identifier '%s' appears in profile objects %d and %d", object.Identifier, first, number)
+		}
+		seen[object.Identifier] = number
+		profiles = append(profiles, profileEntry{Name: object.Identifier, Content: object.Markdown})
+	}
+	return profiles, nil
+}
 
+// listProfiles lists the markdown profiles in the profile directory, the entries of the
+// -profiles-json map (identifier to content) sorted by identifier, or the profiles piped
+// in with -profiles-stdin
+func listProfiles(opts attachOptions) ([]profileEntry, error) {
+	var profiles []profileEntry
+	if opts.ProfilesStdin {
+		return readProfilesJSONL(os.Stdin)
+	}
+	if opts.ProfilesJSON != "" {
+		data, err := os.ReadFile(opts.ProfilesJSON)
+		if err != nil {
+			return nil, fmt.Errorf("reading profiles JSON: %w", err)
+		}
+		var contents map[string]string
+		if err := json.Unmarshal(data, &contents); err != nil {
+			return nil, fmt.Errorf("parsing profiles JSON %s (expected an object mapping identifiers to content): %w", opts.ProfilesJSON, err)
+		}
+		for name, content := range contents {
+			profiles = append(profiles, profileEntry{Name: name, Content: content})
+		}
+		sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+		return profiles, nil
+	}
+
+	profileFiles, err := os.ReadDir(opts.ProfileDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading profile directory: %w", err)
+	}
+	for _, file := range profileFiles {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".md") {
+			// Profiles are matched on the base filename without extension
+			profiles = append(profiles, profileEntry{
+				Name: strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())),
+				Path: filepath.Join(opts.ProfileDir, file.Name()),
+			})
+		}
+	}
+	return profiles, nil
+}
+
+// attachProfiles attaches each markdown profile in the profile directory to the first
+// row that matches its base filename
+func attachProfiles(records [][]string, opts attachOptions) (attachResult, error) {
 	// Find or add the profile summary column
+	var result attachResult
 	headers := records[0]
 	profileColIndex := -1
 	for i, header := range headers {
-		if header == *columnName {
+		if headerMatches(header, opts.ColumnName) {
 			profileColIndex = i
-			log.Printf("Found existing column '%s' at index %d", *columnName, i)
+			log.Printf("Found existing column '%s' at index %d", opts.ColumnName, i)
 			break
 		}
 	}
 
 	// If column doesn't exist, add it
 	if profileColIndex == -1 {
-		headers = append(headers, *columnName)
+		headers = append(headers, opts.ColumnName)
 		profileColIndex = len(headers) - 1
 		records[0] = headers
-		log.Printf("Added new column '%s' at index %d", *columnName, profileColIndex)
+		log.Printf("Added new column '%s' at index %d", opts.ColumnName, profileColIndex)
 
 		// Add empty column value to all existing rows
 		for i := 1; i < len(records); i++ {
@@ -83,95 +297,712 @@ func main() {
 		}
 	}
 
-	// Read profile markdown files
-	profileFiles, err := os.ReadDir(*profileDir)
+	// Find or add the column marking rows with a profile
+	markColIndex := -1
+	if opts.MarkColumn != "" {
+		var added bool
+		markColIndex, headers, added = findHeaderIndex(headers, opts.MarkColumn)
+		records[0] = headers
+		if added {
+			log.Printf("Added new column '%s' at index %d", opts.MarkColumn, markColIndex)
+		} else {
+			log.Printf("Found existing column '%s' at index %d", opts.MarkColumn, markColIndex)
+		}
+	}
+
+	// Resolve the candidate match columns up front
+	var matchIndices []int
+	if opts.MatchColumns != "" {
+		var err error
+		matchIndices, err = resolveMatchColumns(headers, opts.MatchColumns)
+		if err != nil {
+			return result, fmt.Errorf("resolving match columns: %w", err)
+		}
+		log.Printf("Matching against columns %s (indices %v)", opts.MatchColumns, matchIndices)
+		for _, j := range matchIndices {
+			result.MatchColumns = append(result.MatchColumns, headers[j])
+		}
+	}
+
+	// Load the profiles to attach
+	profiles, err := listProfiles(opts)
 	if err != nil {
-		fmt.Printf("Error reading profile directory: %v\n", err)
-		os.Exit(1)
+		return result, err
 	}
 
-	log.Printf("Found %d files in profile directory", len(profileFiles))
+	log.Printf("Found %d profiles", len(profiles))
 
 	// Track statistics
-	attachedCount := 0
-	notFoundCount := 0
+	result.MatchedByColumn = make(map[string]int)
 
-	// Process each markdown file
-	for _, file := range profileFiles {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".md") {
-			// Extract base filename without extension
-			baseFilename := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
-			log.Printf("Processing profile: %s", baseFilename)
-
-			// Read markdown content
-			mdContent, err := os.ReadFile(filepath.Join(*profileDir, file.Name()))
-			if err != nil {
-				fmt.Printf("Error reading markdown file %s: %v\n", file.Name(), err)
-				continue
+	// Index the rows by identifier when the strategy allows it, so each profile is looked up
+	// directly rather than compared against every row
+	var rows *rowIndex
+	if indexer, ok := matcher.IndexerFor(opts.Matcher); ok {
+		rows = indexRows(records, matchIndices, indexer)
+	}
+
+	// Process each profile
+	hasProfile := make(map[int]bool)
+	for _, profile := range profiles {
+		baseFilename := profile.Name
+		log.Printf("Processing profile: %s", baseFilename)
+
+		// Read markdown content
+		mdContent, err := profile.read()
+		if err != nil {
+			if opts.Strict {
+				return result, fmt.Errorf("reading markdown file %s: %w", filepath.Base(profile.Path), err)
 			}
+			fmt.Fprintf(console, "Error reading markdown file %s: %v\n", filepath.Base(profile.Path), err)
+			continue
+		}
 
-			// Find matching row in CSV
-			matched := false
-			for i := 1; i < len(records); i++ {
-				// Check each field in the row for the profile identifier
-				for j, field := range records[i] {
-					if strings.Contains(field, baseFilename) {
-						// Ensure the row has enough columns
-						for len(records[i]) <= profileColIndex {
-							records[i] = append(records[i], "")
-						}
-
-						// Update the row with the profile content
-						records[i][profileColIndex] = string(mdContent)
-
-						log.Printf("Found match in row %d, column %d", i, j)
-						fmt.Printf("Attached profile for %s\n", baseFilename)
-						matched = true
-						attachedCount++
-						break
-					}
+		content := prepareContent(string(mdContent), opts)
+		if valid, err := checkJSON(opts, baseFilename, content); err != nil {
+			return result, err
+		} else if !valid {
+			result.InvalidJSON++
+			result.NotFound++
+			continue
+		}
+
+		// Find matching row in CSV
+		i, j, matched := findMatchingRow(records, rows, matchIndices, baseFilename, opts.Matcher)
+		if matched {
+			// Ensure the row has enough columns
+			for len(records[i]) <= profileColIndex {
+				records[i] = append(records[i], "")
+			}
+
+			// Update the row with the profile content
+			hasProfile[i] = true
+			appended := true
+			if opts.Concat {
+				var value string
+				value, appended = appendWithMarker(records[i][profileColIndex], content, baseFilename, opts.Separator)
+				if appended {
+					records[i][profileColIndex] = value
+				} else {
+					log.Printf("Profile %s already appended to row %d", baseFilename, i)
+					result.AlreadyAppended++
 				}
-				if matched {
-					break
+			} else {
+				records[i][profileColIndex] = content
+			}
+
+			if appended {
+				log.Printf("Found match in row %d, column %d", i, j)
+				fmt.Fprintf(console, "Attached profile for %s\n", baseFilename)
+				result.Attached++
+				if j < len(headers) {
+					result.MatchedByColumn[headers[j]]++
 				}
 			}
+		}
 
-			if !matched {
-				fmt.Printf("Could not find matching row for profile %s\n", baseFilename)
-				notFoundCount++
+		if !matched {
+			fmt.Fprintf(console, "Could not find matching row for profile %s\n", baseFilename)
+			result.NotFound++
+		}
+	}
+
+	result.Rows = len(records) - 1
+	result.Covered = len(hasProfile)
+
+	// Mark every row, including those no profile matched
+	if markColIndex != -1 {
+		for i := 1; i < len(records); i++ {
+			for len(records[i]) <= markColIndex {
+				records[i] = append(records[i], "")
+			}
+			if hasProfile[i] {
+				records[i][markColIndex] = opts.MarkFound
+			} else {
+				records[i][markColIndex] = opts.MarkMissing
 			}
 		}
 	}
 
-	// Write the updated CSV
-	outputFile, err := os.Create(*outputCSV)
+	return result, nil
+}
+
+// joinCSV merges the columns of an enrichment CSV into the rows sharing its key value.
+// Enrichment columns are found or appended by name; rows without a match are left as is.
+func joinCSV(records [][]string, joinPath string, key string, lenient bool, comment rune) (attachResult, error) {
+	var result attachResult
+
+	joinFile, err := os.Open(joinPath)
 	if err != nil {
-		fmt.Printf("Error creating output CSV file: %v\n", err)
+		return result, fmt.Errorf("opening join CSV: %w", err)
+	}
+	defer joinFile.Close()
+
+	reader := csv.NewReader(joinFile)
+	reader.Comment = comment
+	if lenient {
+		reader.FieldsPerRecord = -1
+	}
+	joinRecords, err := reader.ReadAll()
+	if err != nil {
+		return result, fmt.Errorf("reading join CSV: %w", err)
+	}
+	if len(joinRecords) == 0 {
+		return result, fmt.Errorf("join CSV %s is empty", joinPath)
+	}
+
+	// Locate the key in both headers
+	keyIndex := headerIndex(records[0], key)
+	if keyIndex == -1 {
+		return result, fmt.Errorf("join key '%s' not found in CSV header", key)
+	}
+	joinKeyIndex := headerIndex(joinRecords[0], key)
+	if joinKeyIndex == -1 {
+		return result, fmt.Errorf("join key '%s' not found in join CSV header", key)
+	}
+
+	// Find or add a column for every enrichment column
+	headers := records[0]
+	targetIndex := make(map[int]int)
+	for j, column := range joinRecords[0] {
+		if j == joinKeyIndex {
+			continue
+		}
+		var index int
+		var added bool
+		index, headers, added = findHeaderIndex(headers, column)
+		if added {
+			log.Printf("Added new column '%s' at index %d", column, index)
+		} else {
+			log.Printf("Found existing column '%s' at index %d", column, index)
+		}
+		targetIndex[j] = index
+	}
+	records[0] = headers
+
+	// Index enrichment rows by key, keeping the first row for a repeated key
+	joinRows := make(map[string][]string)
+	for i := 1; i < len(joinRecords); i++ {
+		if joinKeyIndex >= len(joinRecords[i]) {
+			continue
+		}
+		value := joinRecords[i][joinKeyIndex]
+		if _, exists := joinRows[value]; exists {
+			log.Printf("Ignoring repeated join key '%s' in join CSV row %d", value, i)
+			continue
+		}
+		joinRows[value] = joinRecords[i]
+	}
+
+	// Copy enrichment values into the matching rows
+	for i := 1; i < len(records); i++ {
+		for len(records[i]) < len(headers) {
+			records[i] = append(records[i], "")
+		}
+
+		joinRow, found := joinRows[records[i][keyIndex]]
+		if !found {
+			result.NotFound++
+			continue
+		}
+		for j, index := range targetIndex {
+			if j < len(joinRow) {
+				records[i][index] = joinRow[j]
+			}
+		}
+		result.Attached++
+	}
+
+	result.Rows = len(records) - 1
+	result.Covered = result.Attached
+	return result, nil
+}
+
+// normalizeHeaders makes header lookups ignore case and surrounding whitespace. It is set
+// by -normalize-headers and never changes the header text that is written out.
+var normalizeHeaders bool
+
+// headerMatches reports whether a CSV header names the given column
+func headerMatches(header, columnName string) bool {
+	if normalizeHeaders {
+		return strings.EqualFold(strings.TrimSpace(header), strings.TrimSpace(columnName))
+	}
+	return header == columnName
+}
+
+// headerIndex returns the index of the named column in a CSV header row, or -1
+func headerIndex(headers []string, columnName string) int {
+	for i, header := range headers {
+		if headerMatches(header, columnName) {
+			return i
+		}
+	}
+	return -1
+}
+
+// findHeaderIndex finds the index of a header in a CSV header row, or adds it if not found
+func findHeaderIndex(headers []string, columnName string) (int, []string, bool) {
+	for i, header := range headers {
+		if headerMatches(header, columnName) {
+			return i, headers, false
+		}
+	}
+	// Header not found, add it
+	return len(headers), append(headers, columnName), true
+}
+
+// printSummary prints the counts of a profile attachment run
+func printSummary(result attachResult, concat bool, validateJSON bool, dedupe bool, duplicateCount int) {
+	fmt.Fprintf(console, "CSV update summary:\n")
+	fmt.Fprintf(console, "- Profiles attached: %d\n", result.Attached)
+	fmt.Fprintf(console, "- Profiles not found: %d\n", result.NotFound)
+	if concat {
+		fmt.Fprintf(console, "- Profiles already appended: %d\n", result.AlreadyAppended)
+	}
+	if validateJSON {
+		fmt.Fprintf(console, "- Profiles failing JSON validation: %d\n", result.InvalidJSON)
+	}
+	if dedupe {
+		fmt.Fprintf(console, "- Duplicate rows removed: %d\n", duplicateCount)
+	}
+	for _, column := range result.MatchColumns {
+		fmt.Fprintf(console, "- Matched via column '%s': %d\n", column, result.MatchedByColumn[column])
+	}
+	fmt.Fprintf(console, "- Coverage: %.1f%% (%d of %d rows)\n", result.coverage(), result.Covered, result.Rows)
+}
+
+// checkCoverage exits non-zero when -min-coverage is set and the run fell short of it
+func checkCoverage(result attachResult, minCoverage float64) {
+	if minCoverage > 0 && result.coverage() < minCoverage {
+		fmt.Fprintf(console, "Error: coverage %.1f%% is below -min-coverage %g%%\n", result.coverage(), minCoverage)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	// Define command-line flags
+	csvPath := flag.String("csv", "data/test/csv/data.csv", "Path to the CSV file")
+	profileDir := flag.String("profiles", "data/test/profile", "Directory containing markdown profiles")
+	outputCSV := flag.String("output", "", "Output CSV file path, or - for stdout (defaults to overwriting input CSV)")
+	profilesJSON := flag.String("profiles-json", "", "JSON file mapping identifiers to profile content, used instead of -profiles")
+	profilesStdin := flag.Bool("profiles-stdin", false, "Read profiles from stdin as JSONL {\"identifier\": ..., \"markdown\": ...} objects, used instead of -profiles")
+	columnName := flag.String("column", "linkedin_profile_summary", "Name of the column to add/update")
+	verbose := flag.Bool("verbose", false, "Enable verbose logging")
+	trim := flag.Bool("trim", false, "Trim leading/trailing whitespace from CSV fields and filenames before matching")
+	matchStrategy := flag.String("match", matcher.StrategyContains, "Matching strategy: contains, exact, regex, url, leaf or fuzzy")
+	maxDistance := flag.Int("max-distance", 1, "Maximum edit distance between a field and a filename for -match fuzzy")
+	matchPattern := flag.String("match-pattern", "", "Regular expression for -match regex; its first capture group (or whole match) must equal the filename")
+	matchColumns := flag.String("match-column", "", "Comma-separated list of columns to search for the identifier, in order (defaults to all columns)")
+	lenient := flag.Bool("lenient", false, "Tolerate rows whose field count differs from the header")
+	dedupe := flag.Bool("dedupe-rows", false, "Keep only one row per -key-column value after enrichment")
+	keyColumn := flag.String("key-column", "", "Column identifying duplicate rows for -dedupe-rows")
+	dedupeKeep := flag.String("dedupe-keep", "first", "Which duplicate row to keep with -dedupe-rows: first or last")
+	headerMap := flag.String("header-map", "", "Comma-separated old=new pairs renaming columns in the output header")
+	flag.BoolVar(&normalizeHeaders, "normalize-headers", false, "Compare header names case-insensitively, ignoring surrounding whitespace (output headers are unchanged)")
+	joinCSVPath := flag.String("join-csv", "", "Enrichment CSV to merge into the rows on -join-key instead of attaching markdown profiles")
+	joinKey := flag.String("join-key", "", "Column shared by both CSVs that rows are joined on")
+	concat := flag.Bool("concat", false, "Append profiles to existing column values instead of replacing them (re-runs don't append twice)")
+	wrapWidth := flag.Int("wrap-width", 0, "Hard-wrap attached content at this many characters, at word boundaries, for reading in a spreadsheet (0 disables wrapping)")
+	transform := flag.String("transform", transformNone, "Transform applied to profile content before it is written: none, plaintext (strip markdown) or singleline (collapse newlines)")
+	concatSep := flag.String("concat-sep", "\n\n", "Separator placed between an existing value and appended content with -concat")
+	sqlitePath := flag.String("sqlite", "", "Write the enriched rows to this SQLite database instead of a CSV file")
+	outputJSONL := flag.String("output-jsonl", "", "Write the enriched rows as JSONL objects keyed by header to this path (or - for stdout) instead of a CSV file")
+	sqliteTable := flag.String("sqlite-table", "profiles", "Table to create in the -sqlite database, replacing any existing one")
+	stripEmpty := flag.Bool("strip-empty-columns", false, "Drop columns whose every data cell is blank before writing")
+	stream := flag.Bool("stream", false, "Read, enrich and write the CSV one row at a time instead of loading it into memory")
+	markColumn := flag.String("mark-column", "", "Column to record whether a profile was found for each row (e.g. profile_exists)")
+	markValues := flag.String("mark-values", "true,false", "Comma-separated values written to -mark-column for rows with and without a profile")
+	byPosition := flag.Bool("by-position", false, "Pair the Nth profile, sorted by filename, with the Nth data row instead of matching identifiers")
+	mappingPath := flag.String("mapping", "", "CSV of identifier,profile_file rows naming each identifier's profile (relative to -profiles); rows are looked up by identifier instead of matched")
+	minCoverage := flag.Float64("min-coverage", 0, "Exit non-zero after writing the output when fewer than this percentage of data rows hold a profile (0 disables the check)")
+	validateJSON := flag.Bool("validate-json", false, "Only attach profiles whose content is valid JSON; invalid ones are reported and left out (or fail the run with -strict)")
+	strict := flag.Bool("strict", false, "Exit with an error on the first profile that can't be read instead of skipping it")
+	normalizeNewlinesTo := flag.String("normalize-newlines", "", "Rewrite every line break inside CSV cells as lf or crlf; records still end in CRLF (by default the writer turns \\n into CRLF and drops a lone \\r)")
+	commentChar := flag.String("comment", "", "Skip CSV lines starting with this character (e.g. #); by default no lines are skipped")
+	flag.Parse()
+
+	// Build the matcher used to compare CSV fields with profile filenames
+	m, err := matcher.New(*matchStrategy, *matchPattern, *maxDistance)
+	if err != nil {
+		fmt.Fprintf(console, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *trim {
+		m = matcher.TrimMatcher{Matcher: m}
+	}
+
+	if *wrapWidth < 0 {
+		fmt.Fprintf(console, "Error: -wrap-width must not be negative, got %d\n", *wrapWidth)
+		os.Exit(1)
+	}
+	switch *transform {
+	case transformNone, transformPlaintext, transformSingleline:
+	default:
+		fmt.Fprintf(console, "Error: -transform must be %s, %s or %s, got '%s'\n", transformNone, transformPlaintext, transformSingleline, *transform)
+		os.Exit(1)
+	}
+	if *wrapWidth > 0 && *transform == transformSingleline {
+		fmt.Fprintln(console, "Error: -wrap-width can't be combined with -transform singleline")
+		os.Exit(1)
+	}
+	if *wrapWidth > 0 && *validateJSON {
+		fmt.Fprintln(console, "Error: -wrap-width can't be combined with -validate-json")
+		os.Exit(1)
+	}
+
+	if *minCoverage < 0 || *minCoverage > 100 {
+		fmt.Fprintf(console, "Error: -min-coverage must be a percentage between 0 and 100, got %g\n", *minCoverage)
+		os.Exit(1)
+	}
+	if *sqlitePath != "" && *outputJSONL != "" {
+		fmt.Fprintln(console, "Error: -sqlite and -output-jsonl cannot be used together")
+		os.Exit(1)
+	}
+
+	if *byPosition {
+		for name, set := range map[string]bool{"-join-csv": *joinCSVPath != "", "-mapping": *mappingPath != "", "-stream": *stream, "-match-column": *matchColumns != ""} {
+			if set {
+				fmt.Fprintf(console, "Error: -by-position can't be combined with %s\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *mappingPath != "" {
+		for name, set := range map[string]bool{"-join-csv": *joinCSVPath != "", "-profiles-json": *profilesJSON != "", "-profiles-stdin": *profilesStdin, "-stream": *stream} {
+			if set {
+				fmt.Fprintf(console, "Error: -mapping can't be combined with %s\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *profilesStdin {
+		for name, set := range map[string]bool{"-profiles-json": *profilesJSON != "", "-join-csv": *joinCSVPath != ""} {
+			if set {
+				fmt.Fprintf(console, "Error: -profiles-stdin can't be combined with %s\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *joinCSVPath != "" && *joinKey == "" {
+		fmt.Fprintln(console, "Error: -join-csv requires -join-key")
+		os.Exit(1)
+	}
+
+	var markFound, markMissing string
+	if *markColumn != "" {
+		if *joinCSVPath != "" {
+			fmt.Fprintln(console, "Error: -mark-column can't be combined with -join-csv")
+			os.Exit(1)
+		}
+		if headerMatches(*markColumn, *columnName) {
+			fmt.Fprintf(console, "Error: -mark-column must differ from -column '%s'\n", *columnName)
+			os.Exit(1)
+		}
+		var ok bool
+		markFound, markMissing, ok = strings.Cut(*markValues, ",")
+		if !ok || strings.Contains(markMissing, ",") {
+			fmt.Fprintf(console, "Error: -mark-values must be two comma-separated values, got '%s'\n", *markValues)
+			os.Exit(1)
+		}
+	}
+
+	comment, err := csvio.ParseComment(*commentChar)
+	if err != nil {
+		fmt.Fprintf(console, "Error: -comment: %v\n", err)
+		os.Exit(1)
+	}
+
+	newlines, err := csvio.ParseNewlines(*normalizeNewlinesTo)
+	if err != nil {
+		fmt.Fprintf(console, "Error: -normalize-newlines: %v\n", err)
+		os.Exit(1)
+	}
+	if newlines != csvio.NewlinesKeep && *outputJSONL != "" {
+		fmt.Fprintln(console, "Error: -normalize-newlines applies to CSV output and can't be combined with -output-jsonl")
 		os.Exit(1)
 	}
-	defer outputFile.Close()
+	if newlines != csvio.NewlinesKeep && *sqlitePath != "" {
+		fmt.Fprintln(console, "Error: -normalize-newlines applies to CSV output and can't be combined with -sqlite")
+		os.Exit(1)
+	}
+
+	renames, err := csvio.ParseHeaderMap(*headerMap)
+	if err != nil {
+		fmt.Fprintf(console, "Error: invalid -header-map: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *stream {
+		for name, set := range map[string]bool{"-dedupe-rows": *dedupe, "-strip-empty-columns": *stripEmpty, "-join-csv": *joinCSVPath != "", "-sqlite": *sqlitePath != "", "-output-jsonl": *outputJSONL != ""} {
+			if set {
+				fmt.Fprintf(console, "Error: -stream can't be combined with %s\n", name)
+				os.Exit(1)
+			}
+		}
+		if _, ok := matcher.IndexerFor(m); !ok {
+			fmt.Fprintln(console, "Error: -stream requires the exact, regex, url or leaf match strategy")
+			os.Exit(1)
+		}
+	}
 
-	writer := csv.NewWriter(outputFile)
+	if *dedupe {
+		if *keyColumn == "" {
+			fmt.Fprintln(console, "Error: -dedupe-rows requires -key-column")
+			os.Exit(1)
+		}
+		if *dedupeKeep != "first" && *dedupeKeep != "last" {
+			fmt.Fprintf(console, "Error: -dedupe-keep must be first or last, got '%s'\n", *dedupeKeep)
+			os.Exit(1)
+		}
+	}
 
-	// Configure the writer to handle CSV fields properly
-	writer.UseCRLF = true // Use Windows-style line endings for better compatibility
+	// Configure logging
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
 
-	// Write all records
-	err = writer.WriteAll(records)
+	log.Printf("Processing CSV file: %s", *csvPath)
+	if *profilesStdin {
+		log.Printf("Reading profiles from stdin")
+	} else if *profilesJSON != "" {
+		log.Printf("Profiles JSON: %s", *profilesJSON)
+	} else {
+		log.Printf("Profile directory: %s", *profileDir)
+	}
+
+	// If no output path specified, use the input path
+	if *outputCSV == "" {
+		*outputCSV = *csvPath
+	}
+
+	// Writing the rows to stdout moves progress output to stderr
+	if *outputCSV == "-" || *outputJSONL == "-" {
+		console = os.Stderr
+	}
+	if *sqlitePath != "" {
+		log.Printf("Output will be written to table '%s' in: %s", *sqliteTable, *sqlitePath)
+	} else if *outputJSONL != "" {
+		log.Printf("Output will be written as JSONL to: %s", *outputJSONL)
+	} else {
+		log.Printf("Output will be written to: %s", *outputCSV)
+	}
+
+	// Read the CSV file
+	csvFile, err := os.Open(*csvPath)
 	if err != nil {
-		fmt.Printf("Error writing CSV: %v\n", err)
+		fmt.Fprintf(console, "Error opening CSV file: %v\n", err)
 		os.Exit(1)
 	}
-	writer.Flush()
+	defer csvFile.Close()
 
-	if err := writer.Error(); err != nil {
-		fmt.Printf("Error flushing CSV writer: %v\n", err)
+	// Parse the CSV
+	reader := csv.NewReader(csvFile)
+	reader.Comment = comment
+	if *lenient {
+		reader.FieldsPerRecord = -1
+	}
+
+	// Stream the rows straight through to the output
+	if *stream {
+		out, err := csvio.CreateStream(*outputCSV, newlines)
+		if err != nil {
+			fmt.Fprintf(console, "Error %v\n", err)
+			os.Exit(1)
+		}
+		result, rowCount, err := streamProfiles(reader, out, attachOptions{
+			ProfileDir:    *profileDir,
+			ProfilesJSON:  *profilesJSON,
+			ProfilesStdin: *profilesStdin,
+			ColumnName:    *columnName,
+			MatchColumns:  *matchColumns,
+			Matcher:       m,
+			Concat:        *concat,
+			Separator:     *concatSep,
+			Transform:     *transform,
+			WrapWidth:     *wrapWidth,
+			MarkColumn:    *markColumn,
+			MarkFound:     markFound,
+			MarkMissing:   markMissing,
+			Strict:        *strict,
+			ValidateJSON:  *validateJSON,
+		}, *lenient, renames)
+		if err != nil {
+			out.Abort()
+			fmt.Fprintf(console, "Error %v\n", err)
+			os.Exit(1)
+		}
+		if err := out.Commit(); err != nil {
+			fmt.Fprintf(console, "Error %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("Streamed %d rows", rowCount)
+		printSummary(result, *concat, *validateJSON, false, 0)
+		fmt.Fprintf(console, "Successfully updated CSV with profile summaries at %s\n", *outputCSV)
+		checkCoverage(result, *minCoverage)
+		return
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		fmt.Fprintf(console, "Error reading CSV: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(records) == 0 {
+		fmt.Fprintln(console, "CSV file is empty")
+		os.Exit(1)
+	}
+
+	log.Printf("Read %d rows from CSV file", len(records))
+
+	// Bring ragged rows in line with the header
+	if *lenient {
+		raggedCount := csvio.NormalizeRowLengths(records)
+		if raggedCount > 0 {
+			fmt.Fprintf(console, "Normalized %d ragged rows to %d fields\n", raggedCount, len(records[0]))
+		}
+	}
+
+	// Enrich the rows, either from an enrichment CSV or from markdown profiles
+	var result attachResult
+	if *joinCSVPath != "" {
+		log.Printf("Joining %s on key '%s'", *joinCSVPath, *joinKey)
+		result, err = joinCSV(records, *joinCSVPath, *joinKey, *lenient, comment)
+	} else if *byPosition {
+		log.Printf("Attaching profiles by row position")
+		result, err = attachByPosition(records, attachOptions{
+			ProfileDir:    *profileDir,
+			ProfilesJSON:  *profilesJSON,
+			ProfilesStdin: *profilesStdin,
+			ColumnName:    *columnName,
+			Concat:        *concat,
+			Separator:     *concatSep,
+			Transform:     *transform,
+			WrapWidth:     *wrapWidth,
+			MarkColumn:    *markColumn,
+			MarkFound:     markFound,
+			MarkMissing:   markMissing,
+			Strict:        *strict,
+			ValidateJSON:  *validateJSON,
+		})
+	} else if *mappingPath != "" {
+		var mapping map[string]string
+		mapping, err = loadMapping(*mappingPath, *profileDir, *trim)
+		if err == nil {
+			log.Printf("Attaching profiles named by mapping %s", *mappingPath)
+			result, err = attachFromMapping(records, attachOptions{
+				ColumnName:   *columnName,
+				MatchColumns: *matchColumns,
+				Concat:       *concat,
+				Separator:    *concatSep,
+				Transform:    *transform,
+				WrapWidth:    *wrapWidth,
+				MarkColumn:   *markColumn,
+				MarkFound:    markFound,
+				MarkMissing:  markMissing,
+				TrimFields:   *trim,
+				Strict:       *strict,
+				ValidateJSON: *validateJSON,
+			}, mapping)
+		}
+	} else {
+		result, err = attachProfiles(records, attachOptions{
+			ProfileDir:    *profileDir,
+			ProfilesJSON:  *profilesJSON,
+			ProfilesStdin: *profilesStdin,
+			ColumnName:    *columnName,
+			MatchColumns:  *matchColumns,
+			Matcher:       m,
+			Concat:        *concat,
+			Separator:     *concatSep,
+			Transform:     *transform,
+			WrapWidth:     *wrapWidth,
+			MarkColumn:    *markColumn,
+			MarkFound:     markFound,
+			MarkMissing:   markMissing,
+			Strict:        *strict,
+			ValidateJSON:  *validateJSON,
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(console, "Error %v\n", err)
+		os.Exit(1)
+	}
+
+	// Drop duplicate rows by key
+	duplicateCount := 0
+	if *dedupe {
+		keyIndex := -1
+		for i, header := range records[0] {
+			if headerMatches(header, *keyColumn) {
+				keyIndex = i
+				break
+			}
+		}
+		if keyIndex == -1 {
+			fmt.Fprintf(console, "Error: key column '%s' not found in CSV header\n", *keyColumn)
+			os.Exit(1)
+		}
+		records, duplicateCount = csvio.DedupeRows(records, keyIndex, *dedupeKeep == "last")
+		log.Printf("Removed %d duplicate rows keyed by '%s'", duplicateCount, *keyColumn)
+	}
+
+	// Rename columns for downstream tooling
+	if len(renames) > 0 {
+		if err := csvio.ApplyHeaderMap(records[0], renames, headerMatches); err != nil {
+			fmt.Fprintf(console, "Error: invalid -header-map: %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("Renamed %d columns", len(renames))
+	}
+
+	// Drop columns nothing was written to
+	if *stripEmpty {
+		var strippedColumns []string
+		records, strippedColumns = csvio.StripEmptyColumns(records)
+		if len(strippedColumns) > 0 {
+			fmt.Fprintf(console, "Removed %d empty columns: %s\n", len(strippedColumns), strings.Join(strippedColumns, ", "))
+		}
+	}
+
+	// Write the updated rows
+	if *sqlitePath != "" {
+		err = writeSQLite(*sqlitePath, *sqliteTable, records)
+		if err != nil {
+			fmt.Fprintf(console, "Error writing SQLite database: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *outputJSONL != "" {
+		if err := csvio.WriteJSONL(*outputJSONL, records); err != nil {
+			fmt.Fprintf(console, "Error %v\n", err)
+			os.Exit(1)
+		}
+	} else if err := csvio.WriteCSV(*outputCSV, records, newlines); err != nil {
+		fmt.Fprintf(console, "Error %v\n", err)
 		os.Exit(1)
 	}
 
 	// Print summary
-	fmt.Printf("CSV update summary:\n")
-	fmt.Printf("- Profiles attached: %d\n", attachedCount)
-	fmt.Printf("- Profiles not found: %d\n", notFoundCount)
-	fmt.Printf("Successfully updated CSV with profile summaries at %s\n", *outputCSV)
+	if *joinCSVPath != "" {
+		fmt.Fprintf(console, "CSV update summary:\n")
+		fmt.Fprintf(console, "- Rows joined: %d\n", result.Attached)
+		fmt.Fprintf(console, "- Rows without a join match: %d\n", result.NotFound)
+		if *dedupe {
+			fmt.Fprintf(console, "- Duplicate rows removed: %d\n", duplicateCount)
+		}
+		fmt.Fprintf(console, "- Coverage: %.1f%% (%d of %d rows)\n", result.coverage(), result.Covered, result.Rows)
+	} else {
+		printSummary(result, *concat, *validateJSON, *dedupe, duplicateCount)
+	}
+	if *sqlitePath != "" {
+		fmt.Fprintf(console, "Successfully wrote %d rows to table '%s' in %s\n", len(records)-1, *sqliteTable, *sqlitePath)
+	} else if *outputJSONL != "" {
+		fmt.Fprintf(console, "Successfully wrote %d rows as JSONL to %s\n", len(records)-1, *outputJSONL)
+	} else {
+		fmt.Fprintf(console, "Successfully updated CSV with profile summaries at %s\n", *outputCSV)
+	}
+	checkCoverage(result, *minCoverage)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// loadMapping reads an identifier,profile_file mapping CSV. Its first row is a header and
+// is skipped. Relative profile paths are resolved against the profile directory.
+func loadMapping(path string, profileDir string, trim bool) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening mapping CSV: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading mapping CSV (expected identifier,profile_file rows): %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("mapping CSV %s is empty", path)
+	}
+
+	mapping := make(map[string]string, len(records)-1)
+	for i, record := range records[1:] {
+		identifier, profileFile := record[0], record[1]
+		if trim {
+			identifier, profileFile = strings.TrimSpace(identifier), strings.TrimSpace(profileFile)
+		}
+		if identifier == "" || profileFile == "" {
+			log.Printf("Ignoring incomplete mapping row %d", i+2)
+			continue
+		}
+		if existing, exists := mapping[identifier]; exists {
+			return nil, fmt.Errorf("identifier '%s' is mapped twice (%s and %s)", identifier, existing, profileFile)
+		}
+		if !filepath.IsAbs(profileFile) {
+			profileFile = filepath.Join(profileDir, profileFile)
+		}
+		mapping[identifier] = profileFile
+	}
+	return mapping, nil
+}
+
+// attachFromMapping attaches profiles named by a -mapping CSV. Each row's candidate fields
+// are looked up in the mapping, in order, and the first identifier found there selects the
+// row's profile file; rows without a mapped identifier are left as is. Unlike attachProfiles,
+// a profile is attached to every row carrying its identifier.
+func attachFromMapping(records [][]string, opts attachOptions, mapping map[string]string) (attachResult, error) {
+	var result attachResult
+	result.MatchedByColumn = make(map[string]int)
+
+	// Find or add the profile summary column, then the column marking rows with a profile
+	profileColIndex, headers, added := findHeaderIndex(records[0], opts.ColumnName)
+	if added {
+		log.Printf("Added new column '%s' at index %d", opts.ColumnName, profileColIndex)
+	} else {
+		log.Printf("Found existing column '%s' at index %d", opts.ColumnName, profileColIndex)
+	}
+	markColIndex := -1
+	if opts.MarkColumn != "" {
+		markColIndex, headers, added = findHeaderIndex(headers, opts.MarkColumn)
+		if added {
+			log.Printf("Added new column '%s' at index %d", opts.MarkColumn, markColIndex)
+		} else {
+			log.Printf("Found existing column '%s' at index %d", opts.MarkColumn, markColIndex)
+		}
+	}
+	records[0] = headers
+
+	var matchIndices []int
+	if opts.MatchColumns != "" {
+		var err error
+		matchIndices, err = resolveMatchColumns(headers, opts.MatchColumns)
+		if err != nil {
+			return result, fmt.Errorf("resolving match columns: %w", err)
+		}
+		for _, j := range matchIndices {
+			result.MatchColumns = append(result.MatchColumns, headers[j])
+		}
+	}
+	log.Printf("Loaded %d mapped identifiers", len(mapping))
+
+	// Profiles are read once, however many rows carry their identifier
+	contents := make(map[string]string)
+	unreadable := make(map[string]bool)
+	referenced := make(map[string]bool)
+	for i := 1; i < len(records); i++ {
+		for len(records[i]) < len(headers) {
+			records[i] = append(records[i], "")
+		}
+
+		candidates := matchIndices
+		if candidates == nil {
+			candidates = make([]int, len(records[i]))
+			for j := range records[i] {
+				candidates[j] = j
+			}
+		}
+		identifier, column := "", -1
+		for _, j := range candidates {
+			field := records[i][j]
+			if opts.TrimFields {
+				field = strings.TrimSpace(field)
+			}
+			if _, mapped := mapping[field]; mapped && field != "" {
+				identifier, column = field, j
+				break
+			}
+		}
+
+		hasProfile := false
+		if identifier != "" {
+			referenced[identifier] = true
+			content, loaded := contents[identifier]
+			if !loaded && !unreadable[identifier] {
+				mdContent, err := os.ReadFile(mapping[identifier])
+				if err != nil && opts.Strict {
+					return result, fmt.Errorf("reading markdown file %s for %s: %w", mapping[identifier], identifier, err)
+				} else if err != nil {
+					fmt.Fprintf(console, "Error reading markdown file %s for %s: %v\n", mapping[identifier], identifier, err)
+					unreadable[identifier] = true
+				} else {
+					content = prepareContent(string(mdContent), opts)
+					valid, err := checkJSON(opts, identifier, content)
+					if err != nil {
+						return result, err
+					}
+					if valid {
+						contents[identifier] = content
+						loaded = true
+					} else {
+						result.InvalidJSON++
+						result.NotFound++
+						unreadable[identifier] = true
+					}
+				}
+			}
+
+			hasProfile = loaded
+			attached := loaded
+			if loaded && opts.Concat {
+				var value string
+				value, attached = appendWithMarker(records[i][profileColIndex], content, identifier, opts.Separator)
+				if attached {
+					records[i][profileColIndex] = value
+				} else {
+					log.Printf("Profile %s already appended to row %d", identifier, i)
+					result.AlreadyAppended++
+				}
+			} else if loaded {
+				records[i][profileColIndex] = content
+			}
+			if attached {
+				log.Printf("Found mapped profile in row %d, column %d", i, column)
+				fmt.Fprintf(console, "Attached profile for %s\n", identifier)
+				result.Attached++
+				result.MatchedByColumn[headers[column]]++
+			}
+		}
+
+		if hasProfile {
+			result.Covered++
+		}
+		if markColIndex != -1 {
+			if hasProfile {
+				records[i][markColIndex] = opts.MarkFound
+			} else {
+				records[i][markColIndex] = opts.MarkMissing
+			}
+		}
+	}
+
+	// Report the mapped identifiers no row carried
+	var missing []string
+	for identifier := range mapping {
+		if !referenced[identifier] {
+			missing = append(missing, identifier)
+		}
+	}
+	sort.Strings(missing)
+	for _, identifier := range missing {
+		fmt.Fprintf(console, "Could not find matching row for profile %s\n", identifier)
+	}
+	result.NotFound += len(missing)
+	result.Rows = len(records) - 1
+	return result, nil
+}
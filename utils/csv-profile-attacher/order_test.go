@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/csvio"
+	"github.com/branexp/linkedin-data-enrichment/internal/matcher"
+)
+
+// orderCSV lists its rows in an order that is neither sorted nor the profile directory's,
+// with the identifier in a plain column, a profile URL and a hierarchical path
+const orderCSV = `name,id,profile_url,path
+Zed,zed,https://www.linkedin.com/in/zed/,team/zed
+Amy,amy,https://www.linkedin.com/in/amy?trk=x,team/amy
+Nobody,nobody,https://www.linkedin.com/in/nobody,team/nobody
+Mia,mia,https://www.linkedin.com/in/mia,team/mia
+Bob,bob,https://www.linkedin.com/in/bob,team/bob
+`
+
+// orderStrategies covers every match strategy, each pointed at the column it reads
+var orderStrategies = []struct {
+	name     string
+	strategy string
+	pattern  string
+	columns  string
+}{
+	{"contains", matcher.StrategyContains, "", "id"},
+	{"exact", matcher.StrategyExact, "", "id"},
+	{"regex", matcher.StrategyRegex, `in/([^/?]+)`, "profile_url"},
+	{"url", matcher.StrategyURL, "", "profile_url"},
+	{"leaf", matcher.StrategyLeaf, "", "path"},
+	{"fuzzy", matcher.StrategyFuzzy, "", "id"},
+}
+
+// Write one profile file per identifier and return options for the profile directory
+func orderOptions(t *testing.T, strategy, pattern, columns string) attachOptions {
+	t.Helper()
+	console = io.Discard
+	log.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	for _, id := range []string{"amy", "bob", "mia", "zed"} {
+		if err := os.WriteFile(filepath.Join(dir, id+".md"), []byte("profile of "+id), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := matcher.New(strategy, pattern, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attachOptions{ProfileDir: dir, ColumnName: "summary", MatchColumns: columns, Matcher: m}
+}
+
+// Read CSV text into records
+func readOrderRecords(t *testing.T, text string) [][]string {
+	t.Helper()
+	records, err := csv.NewReader(strings.NewReader(text)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// Check that the rows come out in the wanted name order
+func checkOrder(t *testing.T, records [][]string, want []string) {
+	t.Helper()
+	var names []string
+	for _, row := range records[1:] {
+		names = append(names, row[0])
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("rows are in order %v, want %v", names, want)
+	}
+}
+
+// Check that every row holds its own profile, and the row without one is left empty
+func checkSummaries(t *testing.T, records [][]string) {
+	t.Helper()
+	summary := headerIndex(records[0], "summary")
+	if summary == -1 {
+		t.Fatalf("no summary column in header %v", records[0])
+	}
+	for _, row := range records[1:] {
+		wantSummary := "profile of " + strings.ToLower(row[0])
+		if row[0] == "Nobody" {
+			wantSummary = ""
+		}
+		if row[summary] != wantSummary {
+			t.Errorf("row %s has summary %q, want %q", row[0], row[summary], wantSummary)
+		}
+	}
+}
+
+var inputOrder = []string{"Zed", "Amy", "Nobody", "Mia", "Bob"}
+
+func TestAttachPreservesRowOrder(t *testing.T) {
+	for _, tt := range orderStrategies {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := orderOptions(t, tt.strategy, tt.pattern, tt.columns)
+			records := readOrderRecords(t, orderCSV)
+			if _, err := attachProfiles(records, opts); err != nil {
+				t.Fatal(err)
+			}
+			checkOrder(t, records, inputOrder)
+			checkSummaries(t, records)
+		})
+	}
+}
+
+func TestStreamPreservesRowOrder(t *testing.T) {
+	for _, tt := range orderStrategies {
+		opts := orderOptions(t, tt.strategy, tt.pattern, tt.columns)
+		if _, ok := matcher.IndexerFor(opts.Matcher); !ok {
+			continue // -stream rejects strategies that can't be indexed
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.csv")
+			out, err := csvio.CreateStream(path, csvio.NewlinesKeep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := streamProfiles(csv.NewReader(strings.NewReader(orderCSV)), out, opts, false, nil); err != nil {
+				out.Abort()
+				t.Fatal(err)
+			}
+			if err := out.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			written, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records := readOrderRecords(t, string(written))
+			checkOrder(t, records, inputOrder)
+			checkSummaries(t, records)
+		})
+	}
+}
+
+func TestDedupeRowsPreservesRowOrder(t *testing.T) {
+	// Amy appears twice; the copy that is kept stays where it was
+	duplicated := orderCSV + "Amy,amy,https://www.linkedin.com/in/amy,team/amy\n"
+	for _, keep := range []struct {
+		name     string
+		keepLast bool
+		want     []string
+	}{
+		{"first", false, inputOrder},
+		{"last", true, []string{"Zed", "Nobody", "Mia", "Bob", "Amy"}},
+	} {
+		for _, tt := range orderStrategies {
+			t.Run(keep.name+"/"+tt.name, func(t *testing.T) {
+				opts := orderOptions(t, tt.strategy, tt.pattern, tt.columns)
+				records := readOrderRecords(t, duplicated)
+				if _, err := attachProfiles(records, opts); err != nil {
+					t.Fatal(err)
+				}
+				records, _ = csvio.DedupeRows(records, headerIndex(records[0], "id"), keep.keepLast)
+				checkOrder(t, records, keep.want)
+			})
+		}
+	}
+}
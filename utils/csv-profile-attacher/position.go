@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// attachByPosition pairs the Nth profile, in listing order (sorted by filename), with the
+// Nth data row, for CSVs without an identifier column. Rows and profiles beyond the shorter
+// of the two are left unpaired and reported.
+func attachByPosition(records [][]string, opts attachOptions) (attachResult, error) {
+	var result attachResult
+
+	// Find or add the profile summary column, then the column marking rows with a profile
+	profileColIndex, headers, added := findHeaderIndex(records[0], opts.ColumnName)
+	if added {
+		log.Printf("Added new column '%s' at index %d", opts.ColumnName, profileColIndex)
+	} else {
+		log.Printf("Found existing column '%s' at index %d", opts.ColumnName, profileColIndex)
+	}
+	markColIndex := -1
+	if opts.MarkColumn != "" {
+		markColIndex, headers, added = findHeaderIndex(headers, opts.MarkColumn)
+		if added {
+			log.Printf("Added new column '%s' at index %d", opts.MarkColumn, markColIndex)
+		} else {
+			log.Printf("Found existing column '%s' at index %d", opts.MarkColumn, markColIndex)
+		}
+	}
+	records[0] = headers
+
+	profiles, err := listProfiles(opts)
+	if err != nil {
+		return result, err
+	}
+	log.Printf("Pairing %d profiles with %d data rows by position", len(profiles), len(records)-1)
+
+	for i := 1; i < len(records); i++ {
+		for len(records[i]) < len(headers) {
+			records[i] = append(records[i], "")
+		}
+
+		hasProfile := false
+		if i <= len(profiles) {
+			profile := profiles[i-1]
+			var content string
+			mdContent, err := profile.read()
+			if err != nil && opts.Strict {
+				return result, fmt.Errorf("reading markdown file %s: %w", filepath.Base(profile.Path), err)
+			} else if err != nil {
+				fmt.Fprintf(console, "Error reading markdown file %s: %v\n", filepath.Base(profile.Path), err)
+			} else {
+				content = prepareContent(string(mdContent), opts)
+				valid, err := checkJSON(opts, profile.Name, content)
+				if err != nil {
+					return result, err
+				}
+				if !valid {
+					result.InvalidJSON++
+					result.NotFound++
+				}
+				hasProfile = valid
+			}
+
+			if hasProfile {
+				attached := true
+				if opts.Concat {
+					var value string
+					value, attached = appendWithMarker(records[i][profileColIndex], content, profile.Name, opts.Separator)
+					if attached {
+						records[i][profileColIndex] = value
+					} else {
+						log.Printf("Profile %s already appended to row %d", profile.Name, i)
+						result.AlreadyAppended++
+					}
+				} else {
+					records[i][profileColIndex] = content
+				}
+				if attached {
+					fmt.Fprintf(console, "Attached profile for %s to row %d\n", profile.Name, i)
+					result.Attached++
+				}
+			}
+		}
+
+		if hasProfile {
+			result.Covered++
+		}
+		if markColIndex != -1 {
+			if hasProfile {
+				records[i][markColIndex] = opts.MarkFound
+			} else {
+				records[i][markColIndex] = opts.MarkMissing
+			}
+		}
+	}
+	result.Rows = len(records) - 1
+
+	// Report a count mismatch, naming the profiles that had no row to go to
+	if unpaired := result.Rows - len(profiles); unpaired == 1 {
+		fmt.Fprintf(console, "Rows left without a profile: 1 (row %d)\n", result.Rows)
+	} else if unpaired > 1 {
+		fmt.Fprintf(console, "Rows left without a profile: %d (rows %d to %d)\n", unpaired, len(profiles)+1, result.Rows)
+	}
+	for _, profile := range profiles[min(len(profiles), result.Rows):] {
+		fmt.Fprintf(console, "No row left for profile %s\n", profile.Name)
+		result.NotFound++
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// quoteIdentifier quotes a table or column name for use in SQLite statements
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqliteColumns derives column names from the CSV header, naming blank headers by
+// position and suffixing repeated ones so the table can always be created. A suffix is
+// counted up past any name already in use, so x, x, x_2 becomes x, x_2, x_2_2 and x_2, x, x
+// becomes x_2, x, x_3.
+func sqliteColumns(headers []string) []string {
+	columns := make([]string, len(headers))
+	taken := make(map[string]bool) // SQLite column names are case-insensitive, so keys are lowercased
+	counts := make(map[string]int)
+	for i, header := range headers {
+		name := strings.TrimSpace(header)
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		key := strings.ToLower(name)
+		counts[key]++
+		if taken[key] {
+			for n := max(counts[key], 2); ; n++ {
+				candidate := fmt.Sprintf("%s_%d", name, n)
+				if !taken[strings.ToLower(candidate)] {
+					name = candidate
+					counts[key] = n
+					break
+				}
+			}
+		}
+		taken[strings.ToLower(name)] = true
+		columns[i] = name
+	}
+	return columns
+}
+
+// writeSQLite replaces the named table in a SQLite database with the CSV records, using
+// the header row for column names and TEXT for every column
+func writeSQLite(path, table string, records [][]string) error {
+	if len(records) == 0 {
+		return fmt.Errorf("no header row to create table from")
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	columns := sqliteColumns(records[0])
+	definitions := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		definitions[i] = quoteIdentifier(column) + " TEXT"
+		placeholders[i] = "?"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DROP TABLE IF EXISTS " + quoteIdentifier(table)); err != nil {
+		return fmt.Errorf("dropping table: %w", err)
+	}
+	create := fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdentifier(table), strings.Join(definitions, ", "))
+	if _, err := tx.Exec(create); err != nil {
+		return fmt.Errorf("creating table: %w", err)
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s VALUES (%s)", quoteIdentifier(table), strings.Join(placeholders, ", "))
+	stmt, err := tx.Prepare(insert)
+	if err != nil {
+		return fmt.Errorf("preparing insert: %w", err)
+	}
+	defer stmt.Close()
+
+	values := make([]any, len(columns))
+	for i := 1; i < len(records); i++ {
+		// Pad short rows so every column gets a value
+		for j := range values {
+			values[j] = ""
+			if j < len(records[i]) {
+				values[j] = records[i][j]
+			}
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return fmt.Errorf("inserting row %d: %w", i, err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/csvio"
+	"github.com/branexp/linkedin-data-enrichment/internal/matcher"
+)
+
+// streamProfiles attaches profiles while copying the CSV row by row, so only the profile
+// index is held in memory. Each profile still goes to the first row that matches it, but
+// rows are matched by looking up their fields' identifiers in the index, which requires a
+// matcher that can be indexed. It returns the result and the number of data rows written.
+func streamProfiles(reader *csv.Reader, out *csvio.Stream, opts attachOptions, lenient bool, renames []csvio.HeaderRename) (attachResult, int, error) {
+	var result attachResult
+	indexer, ok := matcher.IndexerFor(opts.Matcher)
+	if !ok {
+		return result, 0, errors.New("-stream requires the exact, regex, url or leaf match strategy")
+	}
+
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return result, 0, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return result, 0, fmt.Errorf("reading CSV: %w", err)
+	}
+	width := len(headers)
+
+	// Find or add the profile summary column
+	profileColIndex, headers, added := findHeaderIndex(headers, opts.ColumnName)
+	if added {
+		log.Printf("Added new column '%s' at index %d", opts.ColumnName, profileColIndex)
+	} else {
+		log.Printf("Found existing column '%s' at index %d", opts.ColumnName, profileColIndex)
+	}
+
+	// Find or add the column marking rows with a profile
+	markColIndex := -1
+	if opts.MarkColumn != "" {
+		markColIndex, headers, added = findHeaderIndex(headers, opts.MarkColumn)
+		if added {
+			log.Printf("Added new column '%s' at index %d", opts.MarkColumn, markColIndex)
+		} else {
+			log.Printf("Found existing column '%s' at index %d", opts.MarkColumn, markColIndex)
+		}
+	}
+
+	// Resolve the candidate match columns up front
+	var matchIndices []int
+	if opts.MatchColumns != "" {
+		matchIndices, err = resolveMatchColumns(headers, opts.MatchColumns)
+		if err != nil {
+			return result, 0, fmt.Errorf("resolving match columns: %w", err)
+		}
+		for _, j := range matchIndices {
+			result.MatchColumns = append(result.MatchColumns, headers[j])
+		}
+	}
+	result.MatchedByColumn = make(map[string]int)
+
+	// Index the profiles by the key their identifier matches on
+	profiles, err := listProfiles(opts)
+	if err != nil {
+		return result, 0, err
+	}
+	index := make(map[string]profileEntry, len(profiles))
+	for _, profile := range profiles {
+		key := indexer.IndexKey(profile.Name)
+		if existing, exists := index[key]; exists {
+			log.Printf("Ignoring profile %s: %s already matches the same identifier", profile.Name, existing.Name)
+			continue
+		}
+		index[key] = profile
+	}
+	log.Printf("Indexed %d profiles", len(index))
+
+	// Write the header under its output names; lookups keep using the input names
+	outputHeaders := append([]string(nil), headers...)
+	if err := csvio.ApplyHeaderMap(outputHeaders, renames, headerMatches); err != nil {
+		return result, 0, fmt.Errorf("invalid -header-map: %w", err)
+	}
+	if err := out.Write(outputHeaders); err != nil {
+		return result, 0, err
+	}
+
+	attached := make(map[string]bool)
+	rowCount := 0
+	raggedCount := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, rowCount, fmt.Errorf("reading CSV: %w", err)
+		}
+		rowCount++
+
+		// Bring ragged rows in line with the header, then make room for the new column
+		if lenient {
+			var ragged bool
+			if row, ragged = csvio.NormalizeRowLength(row, width, rowCount); ragged {
+				raggedCount++
+			}
+		}
+		for len(row) < len(headers) {
+			row = append(row, "")
+		}
+
+		// Attach every profile whose identifier appears in the candidate fields
+		hasProfile, covered := false, false
+		candidates := matchIndices
+		if candidates == nil {
+			candidates = make([]int, len(row))
+			for j := range row {
+				candidates[j] = j
+			}
+		}
+		for _, j := range candidates {
+			if j >= len(row) {
+				continue
+			}
+			key, ok := indexer.Identifier(row[j])
+			if !ok || attached[key] {
+				continue
+			}
+			profile, found := index[key]
+			if !found {
+				continue
+			}
+			attached[key] = true
+
+			mdContent, err := profile.read()
+			if err != nil {
+				if opts.Strict {
+					return result, rowCount, fmt.Errorf("reading markdown file %s: %w", filepath.Base(profile.Path), err)
+				}
+				fmt.Fprintf(console, "Error reading markdown file %s: %v\n", filepath.Base(profile.Path), err)
+				continue
+			}
+			content := prepareContent(string(mdContent), opts)
+			if valid, err := checkJSON(opts, profile.Name, content); err != nil {
+				return result, rowCount, err
+			} else if !valid {
+				result.InvalidJSON++
+				result.NotFound++
+				continue
+			}
+			hasProfile = true
+			if opts.Concat {
+				value, appended := appendWithMarker(row[profileColIndex], content, profile.Name, opts.Separator)
+				if !appended {
+					log.Printf("Profile %s already appended to row %d", profile.Name, rowCount)
+					result.AlreadyAppended++
+					covered = true
+					continue
+				}
+				row[profileColIndex] = value
+			} else {
+				row[profileColIndex] = content
+			}
+
+			log.Printf("Found match in row %d, column %d", rowCount, j)
+			fmt.Fprintf(console, "Attached profile for %s\n", profile.Name)
+			result.Attached++
+			covered = true
+			if j < len(headers) {
+				result.MatchedByColumn[headers[j]]++
+			}
+		}
+		if covered {
+			result.Covered++
+		}
+
+		if markColIndex != -1 {
+			if hasProfile {
+				row[markColIndex] = opts.MarkFound
+			} else {
+				row[markColIndex] = opts.MarkMissing
+			}
+		}
+
+		if err := out.Write(row); err != nil {
+			return result, rowCount, err
+		}
+	}
+	if raggedCount > 0 {
+		fmt.Fprintf(console, "Normalized %d ragged rows to %d fields\n", raggedCount, width)
+	}
+
+	// Report the profiles no row referred to
+	var missing []string
+	for key, profile := range index {
+		if !attached[key] {
+			missing = append(missing, profile.Name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		fmt.Fprintf(console, "Could not find matching row for profile %s\n", name)
+	}
+	result.NotFound += len(missing)
+	result.Rows = rowCount
+	return result, rowCount, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Transforms applied to profile content before it is written to a cell with -transform
+const (
+	transformNone       = "none"       // Write the markdown as is
+	transformPlaintext  = "plaintext"  // Strip markdown syntax, keeping the text
+	transformSingleline = "singleline" // Collapse newlines and runs of whitespace to single spaces
+)
+
+var (
+	markdownImage   = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink    = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownHeading = regexp.MustCompile(`^#{1,6}\s+`)
+	markdownQuote   = regexp.MustCompile(`^>\s?`)
+	markdownBullet  = regexp.MustCompile(`^(\s*)[-*+]\s+`)
+	markdownRule    = regexp.MustCompile(`^\s*(?:[-*_]\s*){3,}$`)
+	htmlComment     = regexp.MustCompile(`(?s)<!--.*?-->`)
+	whitespaceRun   = regexp.MustCompile(`\s+`)
+	blankLines      = regexp.MustCompile(`\n{3,}`)
+
+	// Inline emphasis and code spans, bold before italic. Single underscores are left alone
+	// because they are common inside identifiers.
+	markdownInline = []*regexp.Regexp{
+		regexp.MustCompile(`\*\*([^*]+)\*\*`),
+		regexp.MustCompile(`__([^_]+)__`),
+		regexp.MustCompile(`\*([^*\s][^*]*)\*`),
+		regexp.MustCompile(`~~([^~]+)~~`),
+		regexp.MustCompile("`([^`]+)`"),
+	}
+)
+
+// transformContent applies a -transform to profile content
+func transformContent(content string, mode string) string {
+	switch mode {
+	case transformPlaintext:
+		return stripMarkdown(content)
+	case transformSingleline:
+		return strings.TrimSpace(whitespaceRun.ReplaceAllString(content, " "))
+	default:
+		return content
+	}
+}
+
+// stripMarkdown reduces markdown to its text: heading and quote markers, rules, code fences,
+// emphasis and comments are removed, and links and images are replaced by their text.
+// Bullets are normalized to "- " so lists still read as lists.
+func stripMarkdown(content string) string {
+	content = htmlComment.ReplaceAllString(content, "")
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.HasPrefix(strings.TrimSpace(line), "```") || markdownRule.MatchString(line) {
+			continue
+		}
+		line = markdownHeading.ReplaceAllString(line, "")
+		line = markdownQuote.ReplaceAllString(line, "")
+		line = markdownBullet.ReplaceAllString(line, "$1- ")
+		line = markdownImage.ReplaceAllString(line, "$1")
+		line = markdownLink.ReplaceAllString(line, "$1")
+		for _, inline := range markdownInline {
+			line = inline.ReplaceAllString(line, "$1")
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// prepareContent applies -transform and then -wrap-width to profile content
+func prepareContent(content string, opts attachOptions) string {
+	content = transformContent(content, opts.Transform)
+	if opts.WrapWidth > 0 {
+		content = wrapContent(content, opts.WrapWidth)
+	}
+	return content
+}
+
+// wrapContent hard-wraps lines longer than width characters at word boundaries for
+// -wrap-width. Continuation lines keep the line's indentation, a word longer than the width
+// gets a line of its own rather than being split, and lines inside code fences or in tables
+// are left alone since wrapping would break them.
+func wrapContent(content string, width int) string {
+	var lines []string
+	inFence := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+		if inFence || strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "|") || utf8.RuneCountInString(line) <= width {
+			lines = append(lines, line)
+			continue
+		}
+
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		current := indent
+		for _, word := range strings.Fields(line) {
+			if current != indent && utf8.RuneCountInString(current)+1+utf8.RuneCountInString(word) > width {
+				lines = append(lines, current)
+				current = indent
+			}
+			if current != indent {
+				current += " "
+			}
+			current += word
+		}
+		lines = append(lines, current)
+	}
+	return strings.Join(lines, "\n")
+}
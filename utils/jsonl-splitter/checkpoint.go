@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkpoint records how far a split of an input file has progressed, along with the
+// filename counters needed to keep duplicate suffixes consistent when resuming
+type checkpoint struct {
+	Input         string         `json:"input"`
+	Line          int            `json:"line"`
+	UsedFilenames map[string]int `json:"usedFilenames"`
+}
+
+// loadCheckpoint reads a checkpoint file, returning nil if it doesn't exist yet
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", path, err)
+	}
+	if cp.UsedFilenames == nil {
+		cp.UsedFilenames = make(map[string]int)
+	}
+	return &cp, nil
+}
+
+// saveCheckpoint writes the checkpoint atomically so a crash mid-write never leaves a
+// truncated file behind
+func saveCheckpoint(path string, cp *checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
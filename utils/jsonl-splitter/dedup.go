@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/dotpath"
+	"github.com/jmespath/go-jmespath"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Which record wins for a repeated -dedup-key value
+const (
+	dedupKeepFirst = "first" // Later records with the key are skipped
+	dedupKeepLast  = "last"  // Earlier records with the key are skipped, so the most recent wins
+)
+
+// Look up a dot-separated path such as "profile.publicIdentifier" in a record, returning the
+// key as text. Strings are used as is and other values as their JSON encoding; a missing or
+// null value has no key.
+func dedupKeyOf(record map[string]interface{}, path string) (string, bool) {
+	value, ok := dotpath.Lookup(record, dotpath.Split(path))
+	if !ok {
+		return "", false
+	}
+
+	switch key := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return key, true
+	default:
+		encoded, err := json.Marshal(key)
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+}
+
+// lastKeyRules holds the checks the main pass makes before writing a record, for
+// -dedup-keep last: -schema, -min-fields and -jmespath come before -dedup-key, while
+// -on-missing-key skip and -seen-file come after it and would otherwise drop the winner
+// once its earlier copies are gone
+type lastKeyRules struct {
+	schema      *jsonschema.Schema
+	minFields   int
+	reshape     *jmespath.JMESPath
+	keyTokens   []string   // Pointer to the naming key
+	skipMissing bool       // -on-missing-key skip
+	seen        *seenStore // nil without -seen-file
+}
+
+// Report whether the main pass would write a record read from line
+func (r lastKeyRules) accepts(record map[string]interface{}, line string) bool {
+	if r.schema != nil && r.schema.Validate(record) != nil {
+		return false
+	}
+	if r.minFields > 0 && len(record) < r.minFields {
+		return false
+	}
+	if r.reshape != nil {
+		result, err := r.reshape.Search(record)
+		if err != nil || result == nil {
+			return false
+		}
+	}
+	publicID, ok := resolvePointer(record, r.keyTokens)
+	if !ok && r.skipMissing {
+		return false
+	}
+	if r.seen != nil {
+		identifier, _ := publicID.(string)
+		if r.seen.seen(seenKeyOf(identifier, line)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Read the inputs once to find the last line holding each -dedup-key value, for
+// -dedup-keep last. Lines are numbered exactly as in the main pass, and only records the
+// rules accept can win, so a copy the main pass drops never takes the key.
+func scanLastKeys(paths []string, multiline bool, keyPath string, rules lastKeyRules) (map[string]int, error) {
+	reader := newMultiReader(paths, multiline, nil)
+	defer reader.Close()
+
+	last := make(map[string]int)
+	lineCount := 0
+	for reader.Next() {
+		lineCount++
+		line := reader.Record()
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			continue
+		}
+		if !rules.accepts(record, line) {
+			continue
+		}
+		if key, ok := dedupKeyOf(record, keyPath); ok {
+			last[key] = lineCount
+		}
+	}
+	return last, reader.Err()
+}
+
+// Write the -dedup-report: every -dedup-key value seen more than once and how many records
+// carried it, most repeated first, as identifier,occurrences CSV rows under a header. It
+// returns the number of values listed.
+func writeDedupReport(path string, occurrences map[string]int) (int, error) {
+	var keys []string
+	for key, count := range occurrences {
+		if count > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if occurrences[keys[i]] != occurrences[keys[j]] {
+			return occurrences[keys[i]] > occurrences[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"identifier", "occurrences"})
+	for _, key := range keys {
+		writer.Write([]string{key, fmt.Sprint(occurrences[key])})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	return len(keys), file.Close()
+}
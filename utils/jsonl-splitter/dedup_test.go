@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Two records share the -dedup-key value "p1": the first has a publicIdentifier and the
+// last doesn't, so the main pass could drop the last one after skipping the first
+const lostKeyInput = `{"publicIdentifier":"jane","profile":{"id":"p1"}}
+{"publicIdentifier":"john","profile":{"id":"p2"}}
+{"profile":{"id":"p1"}}
+`
+
+func TestScanLastKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.jsonl")
+	if err := os.WriteFile(path, []byte(lostKeyInput), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	keyTokens := []string{"publicIdentifier"}
+	tests := []struct {
+		name  string
+		rules lastKeyRules
+		want  map[string]int
+	}{
+		{"last copy wins", lastKeyRules{keyTokens: keyTokens}, map[string]int{"p1": 3, "p2": 2}},
+		{"skipped copy can't win", lastKeyRules{keyTokens: keyTokens, skipMissing: true}, map[string]int{"p1": 1, "p2": 2}},
+		{"seen copy can't win",
+			lastKeyRules{keyTokens: keyTokens, skipMissing: true, seen: &seenStore{previous: map[string]bool{"jane": true}}},
+			map[string]int{"p2": 2}},
+		{"sparse copy can't win", lastKeyRules{keyTokens: keyTokens, minFields: 2}, map[string]int{"p1": 1, "p2": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scanLastKeys([]string{path}, false, "profile.id", tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanLastKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
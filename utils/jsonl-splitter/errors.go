@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseError reports an input line that could not be parsed as a JSON object
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parsing line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError reports a record that could not be serialized, transformed or written
+type WriteError struct {
+	Line   int
+	Stage  string // "reshaping", "renaming", "converting", "transforming", "writing", "verifying" or "recording"
+	Target string // Output location, when known
+	Err    error
+}
+
+func (e *WriteError) Error() string {
+	if e.Target != "" {
+		return fmt.Sprintf("%s line %d to %s: %v", e.Stage, e.Line, e.Target, e.Err)
+	}
+	return fmt.Sprintf("%s line %d: %v", e.Stage, e.Line, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// NameError reports a record whose output filename could not be derived from its key. It
+// is a warning rather than a failure, since the record is still written under a fallback name.
+type NameError struct {
+	Line int
+	Key  string
+	Err  error
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("naming line %d from %s: %v", e.Line, e.Key, e.Err)
+}
+
+func (e *NameError) Unwrap() error {
+	return e.Err
+}
+
+// Categories of collected errors, in summary order
+const (
+	categoryParse = "parse"
+	categoryWrite = "write"
+	categoryOther = "other"
+)
+
+// errorCategory returns the summary category for a collected error
+func errorCategory(err error) string {
+	var parseErr *ParseError
+	var writeErr *WriteError
+	switch {
+	case errors.As(err, &parseErr):
+		return categoryParse
+	case errors.As(err, &writeErr):
+		return categoryWrite
+	default:
+		return categoryOther
+	}
+}
+
+// summarizeErrors counts collected errors by category, e.g. "parse: 2, write: 1"
+func summarizeErrors(errs []error) string {
+	counts := make(map[string]int)
+	for _, err := range errs {
+		counts[errorCategory(err)]++
+	}
+
+	var parts []string
+	for _, category := range []string{categoryParse, categoryWrite, categoryOther} {
+		if counts[category] > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", category, counts[category]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Report whether parse errors make up more than maxRate percent of the lines read, once at
+// least sample lines have been read. A maxRate of 0 disables the check.
+func exceedsErrorRate(parseErrors, lines int, maxRate float64, sample int) bool {
+	if maxRate <= 0 || lines < sample {
+		return false
+	}
+	return float64(parseErrors)*100 > maxRate*float64(lines)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Resolve -input into the files to split, in order. The value is a comma-separated list
+// of paths and glob patterns; a pattern expands to its matches in lexical order, so dated
+// names like profiles-2024-06-*.jsonl are read oldest first. A file named twice is read once.
+func resolveInputs(spec string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		matches := []string{entry}
+		if strings.ContainsAny(entry, "*?[") {
+			var err error
+			matches, err = filepath.Glob(entry)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern '%s': %w", entry, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("pattern '%s' matches no files", entry)
+			}
+			sort.Strings(matches)
+		} else if _, err := os.Stat(entry); err != nil {
+			return nil, err
+		}
+
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no input files given")
+	}
+	return paths, nil
+}
+
+// multiReader reads the records of several input files in turn as one input, opening
+// each file only once the previous one is exhausted
+type multiReader struct {
+	paths     []string
+	multiline bool
+	opened    func(index int, path string, input *bufio.Reader) // Called as each file is opened
+	index     int
+	file      *os.File
+	reader    recordReader
+	err       error
+}
+
+func newMultiReader(paths []string, multiline bool, opened func(index int, path string, input *bufio.Reader)) *multiReader {
+	return &multiReader{paths: paths, multiline: multiline, opened: opened, index: -1}
+}
+
+func (m *multiReader) Next() bool {
+	for m.err == nil {
+		if m.reader == nil {
+			if m.index+1 >= len(m.paths) {
+				return false
+			}
+			m.index++
+			file, err := os.Open(m.paths[m.index])
+			if err != nil {
+				m.err = err
+				return false
+			}
+			m.file = file
+			input := bufio.NewReader(file)
+			if m.opened != nil {
+				m.opened(m.index, m.paths[m.index], input)
+			}
+			if m.multiline {
+				m.reader = newStreamReader(input)
+			} else {
+				m.reader = newLineReader(input)
+			}
+		}
+
+		if m.reader.Next() {
+			return true
+		}
+		if err := m.reader.Err(); err != nil {
+			m.err = fmt.Errorf("%s: %w", m.paths[m.index], err)
+		}
+		m.Close()
+	}
+	return false
+}
+
+func (m *multiReader) Record() string {
+	return m.reader.Record()
+}
+
+func (m *multiReader) Err() error {
+	return m.err
+}
+
+// Close closes the file being read, if any
+func (m *multiReader) Close() {
+	if m.file != nil {
+		m.file.Close()
+		m.file = nil
+	}
+	m.reader = nil
+}
@@ -2,17 +2,50 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/manifest"
+	"github.com/jmespath/go-jmespath"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
 )
 
-// Function to sanitize a string for use as a filename
-func sanitizeFilename(name string) string {
+// Transliterate a string to ASCII by decomposing accented characters and stripping the
+// combining marks; characters with no ASCII base are replaced with underscores
+func toASCII(name string) string {
+	stripMarks := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	stripped, _, err := transform.String(stripMarks, name)
+	if err != nil {
+		stripped = name
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return '_'
+		}
+		return r
+	}, stripped)
+}
+
+// Function to sanitize a string for use as a filename, optionally transliterating it to ASCII first
+func sanitizeFilename(name string, asciiOnly bool) string {
+	if asciiOnly {
+		name = toASCII(name)
+	}
+
 	// Replace invalid characters with underscores
 	re := regexp.MustCompile(`[\\/:*?"<>|]`)
 	sanitized := re.ReplaceAllString(name, "_")
@@ -28,12 +61,106 @@ func sanitizeFilename(name string) string {
 	return sanitized
 }
 
+// Serialize a record as compact or indented JSON, or canonically
+func encodeRecord(value interface{}, pretty bool, canonical bool) ([]byte, error) {
+	if canonical {
+		return marshalCanonical(value, pretty)
+	}
+	if pretty {
+		// Format JSON with indentation for readability
+		return json.MarshalIndent(value, "", "  ")
+	}
+	// Compact JSON format
+	return json.Marshal(value)
+}
+
+// Serialize a record canonically: object keys sorted at every level (encoding/json always
+// sorts map keys), no HTML escaping of <, > and &, and numbers in their shortest form, so the
+// same record always produces the same bytes
+func marshalCanonical(value interface{}, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Run the record through an external command, returning the command's stdout
+func transformRecord(cmdName string, cmdArgs []string, record []byte) ([]byte, error) {
+	cmd := exec.Command(cmdName, cmdArgs...)
+	cmd.Stdin = bytes.NewReader(record)
+	cmd.Stderr = os.Stderr
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// Append a rejected record, with the reason it was rejected, to the rejects JSONL file
+func writeReject(w io.Writer, lineNumber int, reason string, rawLine string) error {
+	entry := struct {
+		Line   int             `json:"line"`
+		Reason string          `json:"reason"`
+		Record json.RawMessage `json:"record"`
+	}{
+		Line:   lineNumber,
+		Reason: reason,
+		Record: json.RawMessage(rawLine),
+	}
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(entryBytes, '\n'))
+	return err
+}
+
 func main() {
 	// Define command-line flags
-	inputFile := flag.String("input", "", "Path to the JSONL file (required)")
+	inputFile := flag.String("input", "", "Path to the JSONL file, or a comma-separated list of paths and glob patterns read in order as one input (required)")
 	outputDir := flag.String("output", "output", "Directory to store the output JSON files")
 	fallbackPrefix := flag.String("fallback-prefix", "item", "Prefix for output filenames when publicIdentifier is not found")
 	prettyPrint := flag.Bool("pretty", false, "Format JSON with indentation for readability")
+	canonical := flag.Bool("canonical", false, "Write canonical JSON (sorted keys, no HTML escaping) so identical records produce identical files")
+	hashNames := flag.Bool("hash-names", false, "Name each file by the first 12 hex digits of the SHA-256 of its publicIdentifier (use -manifest to map names back)")
+	asciiFilenames := flag.Bool("ascii-filenames", false, "Transliterate Unicode identifiers to ASCII filenames (e.g. josé -> jose)")
+	archivePath := flag.String("archive", "", "Write records into a .zip, .tar or .tar.gz archive instead of loose files")
+	compress := flag.Bool("compress", false, "Write each output file gzip-compressed as <name>.json.gz")
+	plan := flag.Bool("plan", false, "Print the planned output path for each record without creating any files")
+	transformCmd := flag.String("transform-cmd", "", "External command (with optional arguments) that each record is piped through before writing")
+	schemaPath := flag.String("schema", "", "Path to a JSON Schema file that each record must validate against")
+	rejectsPath := flag.String("rejects", "", "Path to a JSONL file receiving rejected records and the reason for rejection")
+	minFields := flag.Int("min-fields", 0, "Skip records with fewer top-level fields than this (0 disables the check)")
+	multiline := flag.Bool("multiline", false, "Read a stream of concatenated JSON values that may span multiple lines instead of one record per line")
+	checkpointPath := flag.String("checkpoint", "", "Checkpoint file recording progress; an existing checkpoint resumes after its last processed line")
+	checkpointInterval := flag.Int("checkpoint-interval", 1000, "Number of lines between checkpoint flushes")
+	onCollision := flag.String("on-collision", collisionSuffix, "How to name a record whose output name is taken: suffix (_2, _3, ...), overwrite, skip or hash")
+	maxOutputFiles := flag.Int("max-output-files", 0, "Stop with an error once this many files have been created (0 disables the limit)")
+	manifestPath := flag.String("manifest", "", "Write a manifest of the created files (publicIdentifier, file and content hash) to this path")
+	numShards := flag.Int("num-shards", 0, "Append records to this many shard-NNN.jsonl files instead of writing one file per record (0 disables sharding)")
+	shardBy := flag.String("shard-by", shardByLine, "How -num-shards assigns records: line (round-robin) or key (hash of the key field: publicIdentifier, or the -key-pointer value)")
+	onMissingKey := flag.String("on-missing-key", missingKeyFallback, "What to do with a record without a publicIdentifier: fallback (name it with -fallback-prefix), skip or error")
+	dedupKey := flag.String("dedup-key", "", "Dot-separated path of a field (e.g. publicIdentifier or profile.id) whose repeated values are skipped as duplicates")
+	dedupKeep := flag.String("dedup-keep", dedupKeepFirst, "Which record wins for a repeated -dedup-key value: first or last")
+	dedupReport := flag.String("dedup-report", "", "Write each -dedup-key value seen more than once, with its number of records, to this CSV file")
+	jmespathExpr := flag.String("jmespath", "", "JMESPath expression reshaping each record; its result becomes the file content and null results are skipped")
+	verify := flag.Bool("verify", false, "Re-read each written file and check it parses back to the same record, counting and reporting mismatches")
+	maxErrorRate := flag.Float64("max-error-rate", 0, "Stop with an error once more than this percentage of lines fail to parse, after -error-rate-sample lines (0 disables the check)")
+	errorRateSample := flag.Int("error-rate-sample", 100, "Number of lines read before -max-error-rate is applied")
+	seenPath := flag.String("seen-file", "", "File listing the records written by earlier runs, one identifier per line; listed records are skipped and newly written ones appended")
+	keyPointer := flag.String("key-pointer", "", "RFC 6901 JSON Pointer (e.g. /profile/identifiers/0/value) to the field naming each file, instead of publicIdentifier")
+	var renames renameList
+	flag.Var(&renames, "rename", "Rename a field as old=new before writing, using dot-separated paths for nested fields (e.g. publicIdentifier=username; repeatable)")
 	flag.Parse()
 
 	// Check if input file was provided
@@ -43,111 +170,715 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(*outputDir, 0755); err != nil {
-		fmt.Printf("Error creating output directory: %v\n", err)
+	// Several inputs are split as one, sharing output names and -dedup-key values
+	inputs, err := resolveInputs(*inputFile)
+	if err != nil {
+		fmt.Printf("Error opening input file: %v\n", err)
 		os.Exit(1)
 	}
+	inputList := strings.Join(inputs, ",")
 
-	// Open input file
-	file, err := os.Open(*inputFile)
-	if err != nil {
-		fmt.Printf("Error opening input file: %v\n", err)
+	// Archives are compressed as a whole, so per-file compression only applies to loose files
+	if *compress && *archivePath != "" {
+		fmt.Println("Error: -compress and -archive cannot be used together")
+		os.Exit(1)
+	}
+
+	switch *onCollision {
+	case collisionSuffix, collisionHash, collisionSkip:
+	case collisionOverwrite:
+		// Archive members can't be replaced once written
+		if *archivePath != "" {
+			fmt.Println("Error: -on-collision overwrite cannot be used with -archive")
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Error: -on-collision must be suffix, overwrite, skip or hash, got '%s'\n", *onCollision)
+		os.Exit(1)
+	}
+
+	// Files are named from publicIdentifier unless -key-pointer points elsewhere
+	keyName := "publicIdentifier"
+	keyTokens := []string{keyName}
+	if *keyPointer != "" {
+		keyTokens, err = parsePointer(*keyPointer)
+		if err != nil {
+			fmt.Printf("Error: invalid -key-pointer: %v\n", err)
+			os.Exit(1)
+		}
+		keyName = *keyPointer
+	}
+
+	switch *onMissingKey {
+	case missingKeyFallback, missingKeySkip, missingKeyError:
+	default:
+		fmt.Printf("Error: -on-missing-key must be fallback, skip or error, got '%s'\n", *onMissingKey)
+		os.Exit(1)
+	}
+
+	// Shards are plain JSONL files in the output directory, so per-file options don't apply
+	if *numShards < 0 {
+		fmt.Printf("Error: -num-shards must not be negative, got %d\n", *numShards)
+		os.Exit(1)
+	}
+	if *numShards > 0 {
+		if *shardBy != shardByLine && *shardBy != shardByKey {
+			fmt.Printf("Error: -shard-by must be %s or %s, got '%s'\n", shardByLine, shardByKey, *shardBy)
+			os.Exit(1)
+		}
+		for name, set := range map[string]bool{
+			"-archive": *archivePath != "", "-compress": *compress, "-plan": *plan, "-pretty": *prettyPrint,
+			"-manifest": *manifestPath != "", "-checkpoint": *checkpointPath != "", "-max-output-files": *maxOutputFiles > 0,
+		} {
+			if set {
+				fmt.Printf("Error: -num-shards cannot be used with %s\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *maxErrorRate < 0 || *maxErrorRate > 100 {
+		fmt.Printf("Error: -max-error-rate must be a percentage between 0 and 100, got %g\n", *maxErrorRate)
+		os.Exit(1)
+	}
+	if *errorRateSample < 1 {
+		fmt.Printf("Error: -error-rate-sample must be at least 1, got %d\n", *errorRateSample)
+		os.Exit(1)
+	}
+
+	// Only loose files can be read back as soon as they are written
+	if *verify {
+		for name, set := range map[string]bool{"-archive": *archivePath != "", "-num-shards": *numShards > 0, "-plan": *plan} {
+			if set {
+				fmt.Printf("Error: -verify cannot be used with %s\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *dedupReport != "" && *dedupKey == "" {
+		fmt.Println("Error: -dedup-report requires -dedup-key")
+		os.Exit(1)
+	}
+	// Duplicates are found across the whole input, which a resumed run hasn't seen
+	if *dedupKey != "" {
+		if *dedupKeep != dedupKeepFirst && *dedupKeep != dedupKeepLast {
+			fmt.Printf("Error: -dedup-keep must be %s or %s, got '%s'\n", dedupKeepFirst, dedupKeepLast, *dedupKeep)
+			os.Exit(1)
+		}
+		if *checkpointPath != "" {
+			fmt.Println("Error: -dedup-key cannot be used with -checkpoint")
+			os.Exit(1)
+		}
+		// The limit can stop the run before the winning copy, after the earlier ones were skipped
+		if *dedupKeep == dedupKeepLast && *maxOutputFiles > 0 {
+			fmt.Println("Error: -dedup-keep last cannot be used with -max-output-files")
+			os.Exit(1)
+		}
+	}
+
+	// A manifest must list every file in the split, which a plan or resumed run can't provide
+	if *manifestPath != "" && (*plan || *checkpointPath != "") {
+		fmt.Println("Error: -manifest cannot be used with -plan or -checkpoint")
+		os.Exit(1)
+	}
+
+	// The processor reads only plain .json and .md files, so it would skip every .json.gz
+	// entry of a manifest
+	if *manifestPath != "" && *compress {
+		fmt.Println("Error: -manifest cannot be used with -compress")
 		os.Exit(1)
 	}
-	defer file.Close()
 
-	// Prepare to scan file line by line
-	scanner := bufio.NewScanner(file)
+	// Load an existing checkpoint to resume from
+	var resume *checkpoint
+	if *checkpointPath != "" {
+		if *archivePath != "" {
+			fmt.Println("Error: -checkpoint cannot be used with -archive (archives cannot be resumed)")
+			os.Exit(1)
+		}
+		loaded, err := loadCheckpoint(*checkpointPath)
+		if err != nil {
+			fmt.Printf("Error loading checkpoint: %v\n", err)
+			os.Exit(1)
+		}
+		if loaded != nil && loaded.Input != inputList {
+			fmt.Printf("Error: checkpoint %s belongs to input %s, not %s\n", *checkpointPath, loaded.Input, inputList)
+			os.Exit(1)
+		}
+		resume = loaded
+	}
+
+	// Load the JSON Schema once, up front
+	var schema *jsonschema.Schema
+	if *schemaPath != "" {
+		compiled, err := jsonschema.NewCompiler().Compile(*schemaPath)
+		if err != nil {
+			fmt.Printf("Error loading schema: %v\n", err)
+			os.Exit(1)
+		}
+		schema = compiled
+	}
+
+	// Compile the JMESPath expression once, up front
+	var reshape *jmespath.JMESPath
+	if *jmespathExpr != "" {
+		compiled, err := jmespath.Compile(*jmespathExpr)
+		if err != nil {
+			fmt.Printf("Error: invalid -jmespath expression: %v\n", err)
+			os.Exit(1)
+		}
+		reshape = compiled
+	}
+
+	// Open the rejects file if requested
+	var rejectsFile *os.File
+	if *rejectsPath != "" {
+		// Append when resuming so rejects from the earlier run are kept
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if resume != nil {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		created, err := os.OpenFile(*rejectsPath, flags, 0666)
+		if err != nil {
+			fmt.Printf("Error creating rejects file: %v\n", err)
+			os.Exit(1)
+		}
+		defer created.Close()
+		rejectsFile = created
+	}
+
+	// Load the records written by earlier runs
+	var seen *seenStore
+	if *seenPath != "" {
+		seen, err = openSeenStore(*seenPath, *plan)
+		if err != nil {
+			fmt.Printf("Error opening seen file: %v\n", err)
+			os.Exit(1)
+		}
+		defer seen.Close()
+	}
+
+	// Parse the transform command into base command and arguments
+	transformName, transformArgs := "", []string(nil)
+	if parts := strings.Fields(*transformCmd); len(parts) > 0 {
+		transformName, transformArgs = parts[0], parts[1:]
+	}
+
+	// Set up the destination: an archive if requested, otherwise the output directory
+	var sink outputSink
+	destination := *outputDir
+	if *archivePath != "" {
+		destination = *archivePath
+	}
+	if *plan {
+		sink = &planSink{dir: *outputDir, compress: *compress, archive: *archivePath}
+	} else if *archivePath != "" {
+		archiveSink, err := newArchiveSink(*archivePath)
+		if err != nil {
+			fmt.Printf("Error creating archive: %v\n", err)
+			os.Exit(1)
+		}
+		sink = archiveSink
+	} else {
+		// Create output directory if it doesn't exist
+		if err := os.MkdirAll(*outputDir, 0755); err != nil {
+			fmt.Printf("Error creating output directory: %v\n", err)
+			os.Exit(1)
+		}
+		sink = &dirSink{dir: *outputDir, compress: *compress}
+	}
+
+	// Open the shard files up front so every shard exists, even if it receives no records
+	var shards *shardWriter
+	if *numShards > 0 {
+		created, err := newShardWriter(*outputDir, *numShards)
+		if err != nil {
+			fmt.Printf("Error creating shard files: %v\n", err)
+			os.Exit(1)
+		}
+		shards = created
+	}
+
+	// Find the winning line for each key up front when the last record wins
+	var lastKeyLine map[string]int
+	if *dedupKey != "" && *dedupKeep == dedupKeepLast {
+		scanned, err := scanLastKeys(inputs, *multiline, *dedupKey, lastKeyRules{
+			schema: schema, minFields: *minFields, reshape: reshape,
+			keyTokens: keyTokens, skipMissing: *onMissingKey == missingKeySkip, seen: seen,
+		})
+		if err != nil {
+			fmt.Printf("Error scanning input for -dedup-key: %v\n", err)
+			os.Exit(1)
+		}
+		lastKeyLine = scanned
+	}
+
 	lineCount := 0
 	successCount := 0
 
+	// Read the inputs line by line, or value by value in multiline mode. Lines are numbered
+	// across the whole set, and each file's share is reported in the summary.
+	firstLines := make([]int, len(inputs))
+	firstSuccesses := make([]int, len(inputs))
+	reader := newMultiReader(inputs, *multiline, func(index int, path string, input *bufio.Reader) {
+		firstLines[index], firstSuccesses[index] = lineCount+1, successCount
+		if len(inputs) > 1 {
+			fmt.Printf("Reading %s from line %d\n", path, lineCount+1)
+		}
+
+		// Warn early about inputs that don't look like JSONL, instead of failing on every line
+		for _, warning := range sniffInput(path, input, *multiline) {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+	})
+	defer reader.Close()
+	transformErrorCount := 0
+	verifyFailCount := 0
+	seenSkipCount := 0
+	invalidCount := 0
+	rejectedCount := 0
+	sparseCount := 0
+	nullCount := 0
+	collisionSkipCount := 0
+	duplicateCount := 0
+	missingKeyCount := 0
+	missingKeyLine := 0
+	readCount := 0                   // Non-empty lines read by this run
+	parseErrorCount := 0             // Lines among them that failed to parse
+	errorRateLine := 0               // Line at which -max-error-rate stopped the split
+	seenKeys := make(map[string]int) // Records carrying each -dedup-key value
+	limitReached := false
+
+	// Route a record to the rejects file, if one is configured
+	rejectRecord := func(lineNumber int, reason string, rawLine string) {
+		if rejectsFile == nil {
+			return
+		}
+		if err := writeReject(rejectsFile, lineNumber, reason, rawLine); err != nil {
+			fmt.Printf("Error writing line %d to rejects file: %v\n", lineNumber, err)
+			return
+		}
+		rejectedCount++
+	}
+
+	// Collect per-line errors so they can be summarized by category
+	var lineErrors []error
+	recordError := func(err error) {
+		fmt.Printf("Error %v\n", err)
+		lineErrors = append(lineErrors, err)
+	}
+
+	// Records whose key isn't a string are still written under a fallback name, so they are
+	// warned about and counted apart from the errors
+	fallbackNameCount := 0
+
 	// Track used filenames to handle duplicates
-	usedFilenames := make(map[string]int)
+	names := &nameResolver{used: make(map[string]int)}
+
+	// Entries for the -manifest, one per created file
+	split := &manifest.Manifest{Input: inputList}
+	manifestIndex := make(map[string]int)
+
+	// Restore progress from the checkpoint
+	resumeFrom := 0
+	if resume != nil {
+		resumeFrom = resume.Line
+		names.used = resume.UsedFilenames
+		fmt.Printf("Resuming from checkpoint after line %d\n", resumeFrom)
+	}
+
+	// Add a written record to the -seen-file
+	recordSeen := func(key string) {
+		if seen == nil {
+			return
+		}
+		if err := seen.record(key); err != nil {
+			recordError(&WriteError{Line: lineCount, Stage: "recording", Target: *seenPath, Err: err})
+		}
+	}
+
+	// Persist progress through the given line
+	writeCheckpoint := func(line int) {
+		if *checkpointPath == "" || *plan {
+			return
+		}
+		cp := &checkpoint{Input: inputList, Line: line, UsedFilenames: names.used}
+		if err := saveCheckpoint(*checkpointPath, cp); err != nil {
+			fmt.Printf("Error writing checkpoint: %v\n", err)
+		}
+	}
 
 	// Process each line
-	for scanner.Scan() {
+	for reader.Next() {
 		lineCount++
-		line := scanner.Text()
+		line := reader.Record()
+
+		// Skip lines already handled by a previous run
+		if lineCount <= resumeFrom {
+			continue
+		}
+
+		// Every line before this one is finished, so flush progress periodically
+		if *checkpointInterval > 0 && (lineCount-1)%*checkpointInterval == 0 && lineCount-1 > resumeFrom {
+			writeCheckpoint(lineCount - 1)
+		}
 
 		// Skip empty lines
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
-		// Parse JSON to verify it's valid
+		// Parse JSON to verify it's valid. Stop once so many lines fail that the input is
+		// probably corrupt or in the wrong format, checking again when the sample is complete.
+		readCount++
 		var jsonData map[string]interface{}
 		if err := json.Unmarshal([]byte(line), &jsonData); err != nil {
-			fmt.Printf("Error parsing line %d: %v\n", lineCount, err)
+			recordError(&ParseError{Line: lineCount, Err: err})
+			parseErrorCount++
+			if exceedsErrorRate(parseErrorCount, readCount, *maxErrorRate, *errorRateSample) {
+				errorRateLine = lineCount
+				break
+			}
+			continue
+		}
+		if readCount == *errorRateSample && exceedsErrorRate(parseErrorCount, readCount, *maxErrorRate, *errorRateSample) {
+			errorRateLine = lineCount
+			lineCount--
+			break
+		}
+
+		// Validate against the schema, routing failures to the rejects file
+		if schema != nil {
+			if err := schema.Validate(jsonData); err != nil {
+				fmt.Printf("Line %d failed schema validation: %v\n", lineCount, err)
+				invalidCount++
+				rejectRecord(lineCount, err.Error(), line)
+				continue
+			}
+		}
+
+		// Skip sparse records with too few top-level fields
+		if *minFields > 0 && len(jsonData) < *minFields {
+			fmt.Printf("Skipping sparse line %d: %d fields (minimum %d)\n", lineCount, len(jsonData), *minFields)
+			sparseCount++
+			rejectRecord(lineCount, fmt.Sprintf("sparse record: %d fields, minimum %d", len(jsonData), *minFields), line)
 			continue
 		}
 
-		// Extract publicIdentifier or use fallback
+		// Reshape the record, skipping those the expression maps to null
+		var content interface{} = jsonData
+		if reshape != nil {
+			result, err := reshape.Search(jsonData)
+			if err != nil {
+				recordError(&WriteError{Line: lineCount, Stage: "reshaping", Err: err})
+				continue
+			}
+			if result == nil {
+				fmt.Printf("Skipping line %d: -jmespath expression yielded null\n", lineCount)
+				nullCount++
+				rejectRecord(lineCount, "-jmespath expression yielded null", line)
+				continue
+			}
+			content = result
+		}
+
+		// Skip records repeating a -dedup-key value; records without the field are kept. This
+		// comes after the filters so a rejected copy never claims the key.
+		if *dedupKey != "" {
+			if key, ok := dedupKeyOf(jsonData, *dedupKey); ok {
+				duplicate := seenKeys[key] > 0
+				if lastKeyLine != nil {
+					duplicate = lastKeyLine[key] != lineCount
+				}
+				seenKeys[key]++
+				if duplicate {
+					fmt.Printf("Skipping line %d: duplicate -dedup-key value %s\n", lineCount, key)
+					duplicateCount++
+					rejectRecord(lineCount, "duplicate -dedup-key value "+key, line)
+					continue
+				}
+			}
+		}
+
+		// Stop before this record once the output limit is reached
+		if *maxOutputFiles > 0 && successCount >= *maxOutputFiles {
+			limitReached = true
+			lineCount--
+			break
+		}
+
+		// Extract the key or use fallback
 		var prefix string
-		if publicID, ok := jsonData["publicIdentifier"]; ok {
+		var identifier string
+		if publicID, ok := resolvePointer(jsonData, keyTokens); ok {
 			if publicIDStr, isString := publicID.(string); isString {
-				prefix = sanitizeFilename(publicIDStr)
+				identifier = publicIDStr
+				if *hashNames {
+					prefix = hashName(publicIDStr)
+				} else {
+					prefix = sanitizeFilename(publicIDStr, *asciiFilenames)
+				}
 			} else {
+				fmt.Printf("Warning: %v\n", &NameError{Line: lineCount, Key: keyName, Err: fmt.Errorf("value is a %T, not a string; using fallback name", publicID)})
+				fallbackNameCount++
 				prefix = fmt.Sprintf("%s_%d", *fallbackPrefix, lineCount)
 			}
 		} else {
+			// Count records without the key, then name, skip or stop at them
+			missingKeyCount++
+			if *onMissingKey == missingKeyError {
+				missingKeyLine = lineCount
+				lineCount--
+				break
+			}
+			if *onMissingKey == missingKeySkip {
+				fmt.Printf("Skipping line %d: no %s\n", lineCount, keyName)
+				rejectRecord(lineCount, "no "+keyName+", skipped by -on-missing-key skip", line)
+				continue
+			}
 			prefix = fmt.Sprintf("%s_%d", *fallbackPrefix, lineCount)
 		}
 
-		// Handle duplicate filenames by adding a counter
-		basePrefix := prefix
-		if count, exists := usedFilenames[basePrefix]; exists {
-			count++
-			usedFilenames[basePrefix] = count
-			prefix = fmt.Sprintf("%s_%d", basePrefix, count)
-		} else {
-			usedFilenames[basePrefix] = 1
+		// Skip records an earlier run already wrote
+		var seenKey string
+		if seen != nil {
+			seenKey = seenKeyOf(identifier, line)
+			if seen.seen(seenKey) {
+				fmt.Printf("Skipping line %d: %s was written by an earlier run (-seen-file)\n", lineCount, seenKey)
+				seenSkipCount++
+				continue
+			}
+		}
+
+		// Adapt the written record to the downstream schema once it has been named
+		if record, ok := content.(map[string]interface{}); ok && len(renames) > 0 {
+			if err := renameFields(record, renames); err != nil {
+				recordError(&WriteError{Line: lineCount, Stage: "renaming", Err: err})
+				continue
+			}
+		}
+
+		// In shard mode, append the record to its shard instead of creating a file
+		if shards != nil {
+			outputBytes, err := encodeRecord(content, false, *canonical)
+			if err != nil {
+				recordError(&WriteError{Line: lineCount, Stage: "converting", Err: err})
+				continue
+			}
+			if transformName != "" {
+				outputBytes, err = transformRecord(transformName, transformArgs, outputBytes)
+				if err != nil {
+					recordError(&WriteError{Line: lineCount, Stage: "transforming", Err: fmt.Errorf("command '%s': %w", *transformCmd, err)})
+					transformErrorCount++
+					continue
+				}
+			}
+			key := identifier
+			if key == "" {
+				key = prefix
+			}
+			if location, err := shards.Write(shards.pick(key, *shardBy), outputBytes); err != nil {
+				recordError(&WriteError{Line: lineCount, Stage: "writing", Target: location, Err: err})
+				continue
+			}
+			successCount++
+			recordSeen(seenKey)
+			continue
+		}
+
+		// Handle duplicate filenames according to the collision strategy
+		prefix, ok := names.resolveName(prefix, *onCollision)
+		if !ok {
+			fmt.Printf("Skipping line %d: output name already used\n", lineCount)
+			rejectRecord(lineCount, "duplicate output name skipped by -on-collision skip", line)
+			collisionSkipCount++
+			continue
 		}
 
 		// Create output filename
-		outputFileName := filepath.Join(*outputDir, fmt.Sprintf("%s.json", prefix))
+		outputFileName := fmt.Sprintf("%s.json", prefix)
+
+		// In plan mode only report where the record would go
+		if *plan {
+			location, _ := sink.Write(outputFileName, nil)
+			fmt.Printf("Planned: line %d -> %s\n", lineCount, location)
+			successCount++
+			continue
+		}
 
-		// Open output file
-		outputFile, err := os.Create(outputFileName)
+		// Serialize the record
+		outputBytes, err := encodeRecord(content, *prettyPrint, *canonical)
 		if err != nil {
-			fmt.Printf("Error creating output file for line %d: %v\n", lineCount, err)
+			recordError(&WriteError{Line: lineCount, Stage: "converting", Err: err})
 			continue
 		}
 
-		// Write JSON to file
-		var outputBytes []byte
-		if *prettyPrint {
-			// Format JSON with indentation for readability
-			outputBytes, err = json.MarshalIndent(jsonData, "", "  ")
-		} else {
-			// Compact JSON format
-			outputBytes, err = json.Marshal(jsonData)
+		// Pipe through the external transform command if configured
+		if transformName != "" {
+			outputBytes, err = transformRecord(transformName, transformArgs, outputBytes)
+			if err != nil {
+				recordError(&WriteError{Line: lineCount, Stage: "transforming", Err: fmt.Errorf("command '%s': %w", *transformCmd, err)})
+				transformErrorCount++
+				continue
+			}
 		}
 
+		// Write to the destination
+		location, err := sink.Write(outputFileName, outputBytes)
 		if err != nil {
-			fmt.Printf("Error converting line %d to JSON: %v\n", lineCount, err)
-			outputFile.Close()
+			recordError(&WriteError{Line: lineCount, Stage: "writing", Target: location, Err: err})
 			continue
 		}
 
-		// Write to the output file
-		if _, err := outputFile.Write(outputBytes); err != nil {
-			fmt.Printf("Error writing line %d to file: %v\n", lineCount, err)
-			outputFile.Close()
-			continue
+		// Read the file back to catch storage or encoding faults
+		if *verify {
+			if err := verifyFile(location, *compress, outputBytes); err != nil {
+				recordError(&WriteError{Line: lineCount, Stage: "verifying", Target: location, Err: err})
+				verifyFailCount++
+				continue
+			}
 		}
 
-		outputFile.Close()
 		successCount++
-		fmt.Printf("Created file: %s\n", outputFileName)
+		fmt.Printf("Created file: %s\n", location)
+		recordSeen(seenKey)
+
+		if *manifestPath != "" {
+			entry := manifest.Entry{PublicIdentifier: identifier, File: outputFileName, SHA256: manifest.Hash(outputBytes)}
+			// An overwritten file keeps a single entry describing its final contents
+			if index, exists := manifestIndex[entry.File]; exists {
+				split.Entries[index] = entry
+			} else {
+				manifestIndex[entry.File] = len(split.Entries)
+				split.Entries = append(split.Entries, entry)
+			}
+		}
 	}
 
-	// Check for scanner errors
-	if err := scanner.Err(); err != nil {
+	// Check for read errors
+	if err := reader.Err(); err != nil {
 		fmt.Printf("Error reading input file: %v\n", err)
+		sink.Close()
+		os.Exit(1)
+	}
+
+	// Record how far the input has been processed
+	writeCheckpoint(lineCount)
+
+	// Flush the shard files
+	if shards != nil {
+		if err := shards.Close(); err != nil {
+			fmt.Printf("Error finalizing shard files: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Finalize the destination (flushes archive contents)
+	if err := sink.Close(); err != nil {
+		fmt.Printf("Error finalizing output: %v\n", err)
 		os.Exit(1)
 	}
 
+	// List the created files for incremental processing
+	if *manifestPath != "" {
+		split.Created = time.Now().UTC()
+		if err := manifest.Save(*manifestPath, split); err != nil {
+			fmt.Printf("Error writing manifest: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Print summary
-	fmt.Printf("Processed %d lines, created %d JSON files in %s\n", lineCount, successCount, *outputDir)
+	if shards != nil {
+		fmt.Printf("Processed %d lines, wrote %d records to %d shards in %s (by %s)\n", lineCount, successCount, *numShards, destination, *shardBy)
+		parts := make([]string, len(shards.counts))
+		for i, count := range shards.counts {
+			parts[i] = fmt.Sprintf("%s=%d", filepath.Base(shards.paths[i]), count)
+		}
+		fmt.Printf("Records per shard: %s\n", strings.Join(parts, ", "))
+	} else if *plan {
+		fmt.Printf("Planned %d JSON files for %d lines in %s (%d name collisions resolved by %s)\n", successCount, lineCount, destination, names.collisions, *onCollision)
+	} else {
+		fmt.Printf("Processed %d lines, created %d JSON files in %s\n", lineCount, successCount, destination)
+	}
+	if len(inputs) > 1 {
+		for i, path := range inputs {
+			lines, created := 0, 0
+			if firstLines[i] > 0 {
+				lines, created = lineCount+1-firstLines[i], successCount-firstSuccesses[i]
+				if i+1 < len(inputs) && firstLines[i+1] > 0 {
+					lines, created = firstLines[i+1]-firstLines[i], firstSuccesses[i+1]-firstSuccesses[i]
+				}
+			}
+			fmt.Printf("- %s: %d lines, %d records written\n", path, lines, created)
+		}
+	}
+	if names.collisions > 0 && !*plan {
+		fmt.Printf("Name collisions: %d (resolved by %s)\n", names.collisions, *onCollision)
+	}
+	if seen != nil {
+		fmt.Printf("Records skipped as written by an earlier run (-seen-file): %d\n", seenSkipCount)
+	}
+	if collisionSkipCount > 0 {
+		fmt.Printf("Records skipped for duplicate names: %d\n", collisionSkipCount)
+	}
+	if missingKeyCount > 0 {
+		fmt.Printf("Records without a %s: %d (-on-missing-key %s)\n", keyName, missingKeyCount, *onMissingKey)
+	}
+	if *dedupReport != "" {
+		listed, err := writeDedupReport(*dedupReport, seenKeys)
+		if err != nil {
+			fmt.Printf("Error writing dedup report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Dedup report of %d repeated values written to %s\n", listed, *dedupReport)
+	}
+	if *dedupKey != "" {
+		fmt.Printf("Duplicate records skipped by -dedup-key (keeping %s): %d\n", *dedupKeep, duplicateCount)
+	}
+	if transformName != "" {
+		fmt.Printf("Transform command failures: %d\n", transformErrorCount)
+	}
+	if schema != nil {
+		fmt.Printf("Schema validation failures: %d\n", invalidCount)
+	}
+	if *verify {
+		fmt.Printf("Files failing -verify: %d of %d written\n", verifyFailCount, successCount+verifyFailCount)
+	}
+	if len(lineErrors) > 0 {
+		fmt.Printf("Errors by category: %s\n", summarizeErrors(lineErrors))
+	}
+	if fallbackNameCount > 0 {
+		fmt.Printf("Records given fallback names for a non-string %s: %d\n", keyName, fallbackNameCount)
+	}
+	if *minFields > 0 {
+		fmt.Printf("Sparse records skipped: %d\n", sparseCount)
+	}
+	if reshape != nil {
+		fmt.Printf("Records skipped for null -jmespath results: %d\n", nullCount)
+	}
+	if rejectsFile != nil {
+		fmt.Printf("Rejected records written to %s: %d\n", *rejectsPath, rejectedCount)
+	}
+	if *manifestPath != "" {
+		fmt.Printf("Manifest of %d files written to %s\n", len(split.Entries), *manifestPath)
+	}
+	if errorRateLine > 0 {
+		fmt.Printf("Error: %d of %d lines read (%.1f%%) failed to parse, above -max-error-rate %g%%; stopped at line %d and remaining lines were not processed\n",
+			parseErrorCount, readCount, 100*float64(parseErrorCount)/float64(readCount), *maxErrorRate, errorRateLine)
+		os.Exit(1)
+	}
+	if missingKeyLine > 0 {
+		fmt.Printf("Error: line %d has no %s (-on-missing-key error); remaining lines were not processed\n", missingKeyLine, keyName)
+		os.Exit(1)
+	}
+	if verifyFailCount > 0 {
+		fmt.Printf("Error: %d written files failed -verify; they are left in place but not counted or listed in the manifest\n", verifyFailCount)
+		os.Exit(1)
+	}
+	if limitReached {
+		fmt.Printf("Error: reached -max-output-files limit of %d after line %d; remaining lines were not processed\n", *maxOutputFiles, lineCount)
+		os.Exit(1)
+	}
 }
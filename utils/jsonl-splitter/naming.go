@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Strategies for records whose output name is already taken
+const (
+	collisionSuffix    = "suffix"    // Append _2, _3, ... to later records
+	collisionOverwrite = "overwrite" // Later records replace earlier ones (last wins)
+	collisionSkip      = "skip"      // Later records are dropped (first wins)
+	collisionHash      = "hash"      // Append a short hash of the name and its occurrence
+)
+
+// Policies for records without a publicIdentifier
+const (
+	missingKeyFallback = "fallback" // Name the file <fallback-prefix>_<line>
+	missingKeySkip     = "skip"     // Don't write the record; it is counted and rejected
+	missingKeyError    = "error"    // Stop the split at the record
+)
+
+// hashName returns a fixed-length, collision-resistant filename for an identifier: the first
+// 12 hex digits of its SHA-256
+func hashName(identifier string) string {
+	sum := sha256.Sum256([]byte(identifier))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// nameResolver hands out output names, tracking how many records have used each base name
+type nameResolver struct {
+	used       map[string]int
+	collisions int
+}
+
+// resolveName returns the name to write a record under, or false if the record should be
+// skipped. A suffixed or hashed name that is itself already taken, say by a record whose
+// identifier is literally "x_2", gets a counter appended until it is free.
+func (r *nameResolver) resolveName(base string, strategy string) (string, bool) {
+	count, exists := r.used[base]
+	r.used[base] = count + 1
+	if !exists {
+		return base, true
+	}
+
+	r.collisions++
+	var name string
+	switch strategy {
+	case collisionOverwrite:
+		return base, true
+	case collisionSkip:
+		return "", false
+	case collisionHash:
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", base, count+1)))
+		name = fmt.Sprintf("%s_%s", base, hex.EncodeToString(sum[:4]))
+	default:
+		name = fmt.Sprintf("%s_%d", base, count+1)
+	}
+	candidate := name
+	for n := 2; ; n++ {
+		if _, taken := r.used[candidate]; !taken {
+			break
+		}
+		candidate = fmt.Sprintf("%s_%d", name, n)
+	}
+	r.used[candidate] = 1
+	return candidate, true
+}
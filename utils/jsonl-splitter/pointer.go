@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse an RFC 6901 JSON Pointer such as /profile/identifiers/0/value into its reference
+// tokens, undoing the ~1 (for /) and ~0 (for ~) escapes
+func parsePointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("'%s' must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || (token[j+1] != '0' && token[j+1] != '1')) {
+				return nil, fmt.Errorf("'%s' has a ~ not followed by 0 or 1", pointer)
+			}
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// Resolve pointer tokens against a record. Object members are looked up by name and array
+// elements by a decimal index without leading zeros; anything that doesn't resolve reports
+// false, like a missing field.
+func resolvePointer(record map[string]interface{}, tokens []string) (interface{}, bool) {
+	var value interface{} = record
+	for _, token := range tokens {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[token]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			if token == "" || (len(token) > 1 && token[0] == '0') {
+				return nil, false
+			}
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			value = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// recordReader yields the raw JSON text of each input record
+type recordReader interface {
+	// Next advances to the next record, returning false at the end of input or on error
+	Next() bool
+	// Record returns the raw text of the current record
+	Record() string
+	// Err returns the first error encountered, if any
+	Err() error
+}
+
+// lineReader reads one record per physical line (classic JSONL)
+type lineReader struct {
+	scanner *bufio.Scanner
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{scanner: bufio.NewScanner(r)}
+}
+
+func (l *lineReader) Next() bool {
+	return l.scanner.Scan()
+}
+
+func (l *lineReader) Record() string {
+	return l.scanner.Text()
+}
+
+func (l *lineReader) Err() error {
+	return l.scanner.Err()
+}
+
+// streamReader reads a stream of concatenated JSON values, which may each span
+// multiple lines (e.g. pretty-printed objects separated by blank lines)
+type streamReader struct {
+	decoder *json.Decoder
+	record  json.RawMessage
+	err     error
+}
+
+func newStreamReader(r io.Reader) *streamReader {
+	return &streamReader{decoder: json.NewDecoder(r)}
+}
+
+func (s *streamReader) Next() bool {
+	// Decode into a raw message so values that aren't objects are reported
+	// per record instead of stopping the stream
+	var raw json.RawMessage
+	if err := s.decoder.Decode(&raw); err != nil {
+		if err != io.EOF {
+			s.err = err
+		}
+		return false
+	}
+	s.record = raw
+	return true
+}
+
+func (s *streamReader) Record() string {
+	return string(s.record)
+}
+
+func (s *streamReader) Err() error {
+	return s.err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/dotpath"
+)
+
+// fieldRename moves the value at one dot-separated path of a record to another
+type fieldRename struct {
+	from []string
+	to   []string
+}
+
+// renameList collects the repeatable -rename flag's old=new pairs, in order
+type renameList []fieldRename
+
+func (l *renameList) String() string {
+	pairs := make([]string, len(*l))
+	for i, rename := range *l {
+		pairs[i] = strings.Join(rename.from, ".") + "=" + strings.Join(rename.to, ".")
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l *renameList) Set(value string) error {
+	from, to, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected old=new, got '%s'", value)
+	}
+	rename := fieldRename{from: dotpath.Split(strings.TrimSpace(from)), to: dotpath.Split(strings.TrimSpace(to))}
+	for _, path := range [][]string{rename.from, rename.to} {
+		for _, part := range path {
+			if part == "" {
+				return fmt.Errorf("empty field name in '%s'", value)
+			}
+		}
+	}
+	if isPrefix(rename.from, rename.to) || isPrefix(rename.to, rename.from) {
+		return fmt.Errorf("'%s' moves a field into or out of itself", value)
+	}
+	*l = append(*l, rename)
+	return nil
+}
+
+// Apply the renames to a record in order. A path whose field is missing is left alone; the
+// destination's parent objects are created as needed and a value already there is replaced,
+// so profile.id=username both renames and lifts a nested field.
+func renameFields(record map[string]interface{}, renames renameList) error {
+	for _, rename := range renames {
+		parent, ok := dotpath.LookupObject(record, rename.from[:len(rename.from)-1])
+		if !ok {
+			continue
+		}
+		key := rename.from[len(rename.from)-1]
+		value, exists := parent[key]
+		if !exists {
+			continue
+		}
+
+		target := record
+		for _, part := range rename.to[:len(rename.to)-1] {
+			next, exists := target[part]
+			if !exists {
+				next = make(map[string]interface{})
+				target[part] = next
+			}
+			object, ok := next.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("can't rename %s to %s: %s is not an object", strings.Join(rename.from, "."), strings.Join(rename.to, "."), part)
+			}
+			target = object
+		}
+		delete(parent, key)
+		target[rename.to[len(rename.to)-1]] = value
+	}
+	return nil
+}
+
+// Report whether path starts with prefix
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, part := range prefix {
+		if path[i] != part {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/manifest"
+)
+
+// seenStore is the -seen-file: a plain list, one key per line, of the records written by
+// earlier runs. Records whose key is listed are skipped, and every record this run writes is
+// appended, so repeated daily runs never emit the same profile twice.
+type seenStore struct {
+	file     *os.File        // Appended to as records are written; nil in plan mode
+	previous map[string]bool // Keys listed when the run started
+	added    map[string]bool // Keys appended by this run
+}
+
+// Load the keys in a seen file, which may not exist yet, and open it for appending unless
+// the run only plans
+func openSeenStore(path string, plan bool) (*seenStore, error) {
+	store := &seenStore{previous: make(map[string]bool), added: make(map[string]bool)}
+	existing, err := os.Open(path)
+	if err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if key := strings.TrimRight(scanner.Text(), "\r"); key != "" {
+				store.previous[key] = true
+			}
+		}
+		err = scanner.Err()
+		existing.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if !plan {
+		store.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Key a record by its identifier, or by the SHA-256 of its line when it has none (or one
+// that wouldn't fit on a line), so records named by -fallback-prefix are tracked too
+func seenKeyOf(identifier string, line string) string {
+	if identifier != "" && !strings.ContainsAny(identifier, "\r\n") {
+		return identifier
+	}
+	if identifier != "" {
+		return "sha256:" + manifest.Hash([]byte(identifier))
+	}
+	return "sha256:" + manifest.Hash([]byte(strings.TrimSpace(line)))
+}
+
+// Report whether an earlier run wrote a record with this key
+func (s *seenStore) seen(key string) bool {
+	return s.previous[key]
+}
+
+// Append the key of a record this run wrote, once
+func (s *seenStore) record(key string) error {
+	if s.file == nil || s.previous[key] || s.added[key] {
+		return nil
+	}
+	if _, err := fmt.Fprintln(s.file, key); err != nil {
+		return err
+	}
+	s.added[key] = true
+	return nil
+}
+
+func (s *seenStore) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
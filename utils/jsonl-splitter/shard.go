@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+)
+
+// Ways of choosing a record's shard with -shard-by
+const (
+	shardByLine = "line" // Round-robin over the records written
+	shardByKey  = "key"  // Hash of the record's publicIdentifier, so a key always lands in the same shard
+)
+
+// shardWriter appends records as JSONL lines to a fixed set of files,
+// shard-000.jsonl through shard-(N-1).jsonl
+type shardWriter struct {
+	paths   []string
+	files   []*os.File
+	writers []*bufio.Writer
+	counts  []int
+	written int
+}
+
+// Create (or truncate) the shard files in dir
+func newShardWriter(dir string, count int) (*shardWriter, error) {
+	s := &shardWriter{counts: make([]int, count)}
+	for i := 0; i < count; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("shard-%03d.jsonl", i))
+		file, err := os.Create(path)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.paths = append(s.paths, path)
+		s.files = append(s.files, file)
+		s.writers = append(s.writers, bufio.NewWriter(file))
+	}
+	return s, nil
+}
+
+// Pick the shard for the next record: by its key's hash, or round-robin
+func (s *shardWriter) pick(key string, strategy string) int {
+	if strategy == shardByKey {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return int(h.Sum32() % uint32(len(s.files)))
+	}
+	return s.written % len(s.files)
+}
+
+// Append a record to a shard as a single line. The record is compacted first, since
+// -transform-cmd output may span several lines.
+func (s *shardWriter) Write(shard int, record []byte) (string, error) {
+	var line bytes.Buffer
+	if err := json.Compact(&line, record); err != nil {
+		return s.paths[shard], fmt.Errorf("record is not valid JSON: %w", err)
+	}
+	line.WriteByte('\n')
+	if _, err := s.writers[shard].Write(line.Bytes()); err != nil {
+		return s.paths[shard], err
+	}
+	s.counts[shard]++
+	s.written++
+	return s.paths[shard], nil
+}
+
+// Flush and close every shard file
+func (s *shardWriter) Close() error {
+	var firstErr error
+	for i, file := range s.files {
+		if err := s.writers[i].Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// outputSink receives each serialized record under its output filename
+type outputSink interface {
+	// Write stores data under name and returns a human-readable location for it
+	Write(name string, data []byte) (string, error)
+	Close() error
+}
+
+// dirSink writes each record as a loose file in a directory, optionally gzip-compressed
+type dirSink struct {
+	dir      string
+	compress bool // Write <name>.gz files through a gzip.Writer
+}
+
+func (s *dirSink) Write(name string, data []byte) (string, error) {
+	path := filepath.Join(s.dir, name)
+	if !s.compress {
+		return path, os.WriteFile(path, data, 0666)
+	}
+
+	path += ".gz"
+	file, err := os.Create(path)
+	if err != nil {
+		return path, err
+	}
+
+	// Close the gzip writer before the file so the compressed stream isn't truncated
+	gz := gzip.NewWriter(file)
+	_, err = gz.Write(data)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return path, err
+}
+
+func (s *dirSink) Close() error {
+	return nil
+}
+
+// planSink reports where each record would be written without writing anything
+type planSink struct {
+	dir      string
+	compress bool
+	archive  string // Archive path, when planning archive output
+}
+
+func (s *planSink) Write(name string, data []byte) (string, error) {
+	if s.archive != "" {
+		return fmt.Sprintf("%s (in %s)", name, s.archive), nil
+	}
+	path := filepath.Join(s.dir, name)
+	if s.compress {
+		path += ".gz"
+	}
+	return path, nil
+}
+
+func (s *planSink) Close() error {
+	return nil
+}
+
+// tarSink streams each record as an entry of a (optionally gzipped) tar archive
+type tarSink struct {
+	path string
+	file *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+}
+
+func (s *tarSink) Write(name string, data []byte) (string, error) {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	location := fmt.Sprintf("%s (in %s)", name, s.path)
+	if err := s.tw.WriteHeader(header); err != nil {
+		return location, err
+	}
+	_, err := s.tw.Write(data)
+	return location, err
+}
+
+func (s *tarSink) Close() error {
+	// Close the writers innermost first so all buffered data reaches the file
+	err := s.tw.Close()
+	if s.gz != nil {
+		if gzErr := s.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if fileErr := s.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// zipSink streams each record as an entry of a zip archive
+type zipSink struct {
+	path string
+	file *os.File
+	zw   *zip.Writer
+}
+
+func (s *zipSink) Write(name string, data []byte) (string, error) {
+	location := fmt.Sprintf("%s (in %s)", name, s.path)
+	w, err := s.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return location, err
+	}
+	_, err = w.Write(data)
+	return location, err
+}
+
+func (s *zipSink) Close() error {
+	err := s.zw.Close()
+	if fileErr := s.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// newArchiveSink creates an archive sink whose format is chosen by the path's extension
+// (.zip, .tar, .tar.gz or .tgz)
+func newArchiveSink(path string) (outputSink, error) {
+	lower := strings.ToLower(path)
+	var format string
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		format = "zip"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		format = "tar.gz"
+	case strings.HasSuffix(lower, ".tar"):
+		format = "tar"
+	default:
+		return nil, fmt.Errorf("unsupported archive extension for %s (use .zip, .tar, .tar.gz or .tgz)", path)
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating archive directory: %w", err)
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating archive file: %w", err)
+	}
+
+	switch format {
+	case "zip":
+		return &zipSink{path: path, file: file, zw: zip.NewWriter(file)}, nil
+	case "tar.gz":
+		gz := gzip.NewWriter(file)
+		return &tarSink{path: path, file: file, gz: gz, tw: tar.NewWriter(gz)}, nil
+	default:
+		return &tarSink{path: path, file: file, tw: tar.NewWriter(file)}, nil
+	}
+}
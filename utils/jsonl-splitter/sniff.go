@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// sniffSize is how much of the input is peeked at to guess its format
+const sniffSize = 4096
+
+// Describe what looks wrong about an input, from its extension and first bytes, for the
+// warnings printed before splitting. The peek doesn't consume anything from the reader.
+func sniffInput(path string, reader *bufio.Reader, multiline bool) []string {
+	var warnings []string
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".jsonl" && ext != ".ndjson" && !multiline {
+		warnings = append(warnings, fmt.Sprintf("%s doesn't have a .jsonl or .ndjson extension; expecting one JSON object per line", path))
+	}
+
+	head, _ := reader.Peek(sniffSize)
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if len(head) == 0 {
+		return warnings
+	}
+	switch head[0] {
+	case '{':
+	case '[':
+		warnings = append(warnings, fmt.Sprintf("%s starts with '[' and looks like a JSON array, not JSONL; its records won't parse one per line. Convert it first, e.g. jq -c '.[]' %s > records.jsonl", path, path))
+	default:
+		warnings = append(warnings, fmt.Sprintf("%s doesn't start with a JSON object ('{'); it may not be JSONL", path))
+	}
+	return warnings
+}
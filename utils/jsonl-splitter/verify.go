@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Re-read a file just written by a dirSink and check it holds the record it was given, for
+// -verify. Both are compared as canonical JSON, so only a change in content counts, with
+// numbers kept as written; data that isn't JSON, such as a -transform-cmd's output, must
+// match byte for byte.
+func verifyFile(path string, compressed bool, expected []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var input io.Reader = file
+	if compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		input = gz
+	}
+	written, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+
+	want, err := canonicalForm(expected)
+	if err != nil {
+		if !bytes.Equal(written, expected) {
+			return fmt.Errorf("content differs from the %d bytes written", len(expected))
+		}
+		return nil
+	}
+	got, err := canonicalForm(written)
+	if err != nil {
+		return fmt.Errorf("written file doesn't parse back: %w", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("written file parses to a different record")
+	}
+	return nil
+}
+
+// Parse JSON data and serialize it canonically
+func canonicalForm(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return marshalCanonical(value, false)
+}
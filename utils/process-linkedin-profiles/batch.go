@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchLinger is how long a partly filled batch waits for more files before it is sent
+// anyway, so the last files of a run (or a slow -watch trickle) aren't held back
+const batchLinger = time.Second
+
+// batchInstructions opens every batched fabric input, telling the model how the profiles are
+// delimited and how to mark its outputs so they can be split back into one file each
+const batchInstructions = "The input below contains %d separate profiles. Each one starts with a line of the form \"=== PROFILE k OF %d ===\". " +
+	"Process every profile on its own, exactly as you would if it were the only input, and start the output for each one with a line " +
+	"\"=== OUTPUT k ===\" using the same number k. Write the outputs in order and write nothing before the first marker.\n\n"
+
+// fabricBatcher groups files that use the same fabric command into one fabric run for
+// -batch-size, splitting the output back into one result per file. Files wait for a batch
+// to fill, or for batchLinger to pass; slots bounds the fabric runs in flight to -workers.
+type fabricBatcher struct {
+	ctx     context.Context
+	config  Config
+	size    int
+	slots   chan struct{}
+	mutex   sync.Mutex
+	pending map[string]*fabricBatch // Batches still being filled, by fabric arguments
+}
+
+// fabricBatch is a batch of files waiting to be sent to fabric together
+type fabricBatch struct {
+	fabArgs []string
+	key     string
+	items   []*batchItem
+	timer   *time.Timer
+}
+
+// batchItem is one file's input and the channel its batch reports back on
+type batchItem struct {
+	input []byte
+	done  chan []byte // Receives the file's output, or nil when it must be run on its own
+}
+
+func newFabricBatcher(ctx context.Context, config Config) *fabricBatcher {
+	return &fabricBatcher{
+		ctx:     ctx,
+		config:  config,
+		size:    config.BatchSize,
+		slots:   make(chan struct{}, config.MaxWorkers),
+		pending: make(map[string]*fabricBatch),
+	}
+}
+
+// Run fabric on one file's content as part of a batch, returning its share of the output.
+// When the batch fails or its output can't be split reliably, the file is run through fabric
+// on its own, with errors and stderr reported as for an unbatched file.
+func (b *fabricBatcher) run(ctx context.Context, fabArgs []string, content []byte, stderr io.Writer) ([]byte, error) {
+	item := &batchItem{input: buildFabricInput(b.config, content), done: make(chan []byte, 1)}
+	b.add(fabArgs, item)
+
+	select {
+	case output := <-item.done:
+		if output != nil {
+			return output, nil
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// Fall back to a run of its own, which still takes a fabric slot
+	select {
+	case b.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-b.slots }()
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "fabric", fabArgs...)
+	cmd.Stdin = bytes.NewReader(item.input)
+	cmd.Stdout = &output
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	return output.Bytes(), err
+}
+
+// Add an item to the batch for its fabric arguments, sending the batch once it is full
+func (b *fabricBatcher) add(fabArgs []string, item *batchItem) {
+	key := strings.Join(fabArgs, "\x00")
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	batch, exists := b.pending[key]
+	if !exists {
+		batch = &fabricBatch{fabArgs: fabArgs, key: key}
+		batch.timer = time.AfterFunc(batchLinger, func() { b.flush(batch) })
+		b.pending[key] = batch
+	}
+	batch.items = append(batch.items, item)
+	if len(batch.items) >= b.size {
+		batch.timer.Stop()
+		delete(b.pending, key)
+		go b.send(batch)
+	}
+}
+
+// Send a partly filled batch whose linger time has passed, unless it was sent in the meantime
+func (b *fabricBatcher) flush(batch *fabricBatch) {
+	b.mutex.Lock()
+	if b.pending[batch.key] != batch {
+		b.mutex.Unlock()
+		return
+	}
+	delete(b.pending, batch.key)
+	b.mutex.Unlock()
+	b.send(batch)
+}
+
+// Run fabric once for a batch and hand each item its output, or tell every item to run on
+// its own when fabric fails or the output doesn't split into one part per item. A batch of
+// one is always run on its own, without the batch instructions.
+func (b *fabricBatcher) send(batch *fabricBatch) {
+	var outputs [][]byte
+	if len(batch.items) > 1 {
+		outputs = b.runBatch(batch)
+	}
+	for i, item := range batch.items {
+		if outputs != nil {
+			item.done <- outputs[i]
+		} else {
+			item.done <- nil
+		}
+	}
+}
+
+// Run fabric on a batch's combined input, returning the split outputs or nil on failure
+func (b *fabricBatcher) runBatch(batch *fabricBatch) [][]byte {
+	select {
+	case b.slots <- struct{}{}:
+	case <-b.ctx.Done():
+		return nil
+	}
+	defer func() { <-b.slots }()
+
+	count := len(batch.items)
+	var input bytes.Buffer
+	fmt.Fprintf(&input, batchInstructions, count, count)
+	for i, item := range batch.items {
+		fmt.Fprintf(&input, "=== PROFILE %d OF %d ===\n", i+1, count)
+		input.Write(bytes.TrimRight(item.input, "\n"))
+		input.WriteString("\n\n")
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(b.ctx, "fabric", batch.fabArgs...)
+	cmd.Stdin = &input
+	cmd.Stdout = &output
+	cmd.Stderr = os.Stderr
+	if b.config.SummaryOnly {
+		cmd.Stderr = io.Discard // Failed files are re-run on their own, which reports them
+	}
+	if err := cmd.Run(); err != nil {
+		if b.config.Verbose {
+			fmt.Fprintf(console, "Batch of %d files failed (%v); running them one at a time\n", count, err)
+		}
+		return nil
+	}
+
+	outputs, err := splitBatchOutput(output.Bytes(), count)
+	if err != nil {
+		if b.config.Verbose {
+			fmt.Fprintf(console, "Batch output of %d files can't be split (%v); running them one at a time\n", count, err)
+		}
+		return nil
+	}
+	return outputs
+}
+
+// Split a batched fabric output at its "=== OUTPUT k ===" marker lines. The markers must
+// run from 1 to count, each exactly once and in order, with only blank lines before the
+// first, and no part may be empty; anything else means the outputs can't be told apart.
+func splitBatchOutput(output []byte, count int) ([][]byte, error) {
+	var parts [][]byte
+	var current []byte
+	for _, line := range bytes.SplitAfter(output, []byte("\n")) {
+		if k, ok := outputMarker(line); ok {
+			if k != len(parts)+1 {
+				return nil, fmt.Errorf("found output marker %d where %d was expected", k, len(parts)+1)
+			}
+			if len(parts) > 0 {
+				parts[len(parts)-1] = current
+			}
+			parts = append(parts, nil)
+			current = nil
+			continue
+		}
+		if len(parts) == 0 {
+			if len(bytes.TrimSpace(line)) > 0 {
+				return nil, fmt.Errorf("text before the first output marker")
+			}
+			continue
+		}
+		current = append(current, line...)
+	}
+	if len(parts) != count {
+		return nil, fmt.Errorf("found %d output markers for %d files", len(parts), count)
+	}
+	parts[len(parts)-1] = current
+
+	for i, part := range parts {
+		part = bytes.TrimSpace(part)
+		if len(part) == 0 {
+			return nil, fmt.Errorf("output %d is empty", i+1)
+		}
+		parts[i] = append(part, '\n')
+	}
+	return parts, nil
+}
+
+// Parse a "=== OUTPUT k ===" marker line, returning k
+func outputMarker(line []byte) (int, bool) {
+	text := strings.TrimSpace(string(line))
+	if !strings.HasPrefix(text, "=== OUTPUT ") || !strings.HasSuffix(text, " ===") {
+		return 0, false
+	}
+	k, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(text, "=== OUTPUT "), " ==="))
+	if err != nil || k < 1 {
+		return 0, false
+	}
+	return k, true
+}
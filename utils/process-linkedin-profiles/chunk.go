@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"unicode/utf8"
+)
+
+// Split content larger than limit into chunks of at most limit bytes for -chunk-bytes.
+// JSON objects and arrays are split by structure so every chunk is valid JSON. Markdown
+// breaks between sections where possible; a section that is larger than the limit on its
+// own breaks between lines, and a single oversized line at its last space within the
+// limit. Content within the limit is returned as one chunk.
+func splitChunks(content []byte, limit int) ([][]byte, error) {
+	if limit <= 0 || len(content) <= limit {
+		return [][]byte{content}, nil
+	}
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && json.Valid(trimmed) {
+		return splitJSONChunks(trimmed, limit)
+	}
+
+	var chunks [][]byte
+	var current []byte
+	add := func(piece []byte) {
+		if len(current) > 0 && len(current)+len(piece) > limit {
+			chunks = append(chunks, current)
+			current = nil
+		}
+		current = append(current, piece...)
+	}
+
+	// Whole sections are packed into as few chunks as the limit allows; an oversized one
+	// starts a chunk of its own so its heading stays with its first lines
+	for _, section := range splitSections(content) {
+		if len(section) <= limit {
+			add(section)
+			continue
+		}
+		if len(current) > 0 {
+			chunks = append(chunks, current)
+			current = nil
+		}
+		for _, line := range bytes.SplitAfter(section, []byte("\n")) {
+			for len(line) > limit {
+				cut := splitPoint(line, limit)
+				add(line[:cut])
+				line = line[cut:]
+			}
+			if len(line) > 0 {
+				add(line)
+			}
+		}
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks, nil
+}
+
+// Split a JSON value into compact JSON chunks of at most limit bytes. An array is split
+// between its elements and an object between its members; a member too large on its own
+// is split between its elements when it holds an array, repeating the member name in each
+// chunk. A value that can't be split within the limit is an error.
+func splitJSONChunks(content []byte, limit int) ([][]byte, error) {
+	switch content[0] {
+	case '[':
+		var elements []json.RawMessage
+		if err := json.Unmarshal(content, &elements); err != nil {
+			return nil, err
+		}
+		return packJSON("[", "]", elements, limit)
+	case '{':
+	default:
+		return nil, fmt.Errorf("JSON value is %d bytes, over the -chunk-bytes limit of %d, and can't be split", len(content), limit)
+	}
+
+	// Members are read in order with a decoder, since a map would lose it
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	var chunks [][]byte
+	var members []json.RawMessage
+	size := 2
+	flush := func() {
+		if len(members) > 0 {
+			chunks = append(chunks, joinJSON("{", "}", members))
+			members, size = nil, 2
+		}
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		name, _ := json.Marshal(token.(string))
+		var member bytes.Buffer
+		member.Write(name)
+		member.WriteByte(':')
+		if err := json.Compact(&member, value); err != nil {
+			return nil, err
+		}
+
+		if 2+member.Len() <= limit {
+			if len(members) > 0 && size+1+member.Len() > limit {
+				flush()
+			}
+			if len(members) > 0 {
+				size++
+			}
+			members = append(members, member.Bytes())
+			size += member.Len()
+			continue
+		}
+		var elements []json.RawMessage
+		if err := json.Unmarshal(value, &elements); err != nil {
+			return nil, fmt.Errorf("JSON member %s is %d bytes, over the -chunk-bytes limit of %d, and can't be split", name, member.Len(), limit)
+		}
+		flush()
+		parts, err := packJSON("{"+string(name)+":[", "]}", elements, limit)
+		if err != nil {
+			return nil, fmt.Errorf("JSON member %s: %w", name, err)
+		}
+		chunks = append(chunks, parts...)
+	}
+	flush()
+	return chunks, nil
+}
+
+// Pack JSON elements between open and close into as few compact chunks of at most limit
+// bytes as possible
+func packJSON(open, close string, elements []json.RawMessage, limit int) ([][]byte, error) {
+	var chunks [][]byte
+	var current []json.RawMessage
+	size := len(open) + len(close)
+	for _, element := range elements {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, element); err != nil {
+			return nil, err
+		}
+		if len(open)+compact.Len()+len(close) > limit {
+			return nil, fmt.Errorf("JSON element is %d bytes, over the -chunk-bytes limit of %d, and can't be split", compact.Len(), limit)
+		}
+		if len(current) > 0 && size+1+compact.Len() > limit {
+			chunks = append(chunks, joinJSON(open, close, current))
+			current, size = nil, len(open)+len(close)
+		}
+		if len(current) > 0 {
+			size++
+		}
+		current = append(current, compact.Bytes())
+		size += compact.Len()
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, joinJSON(open, close, current))
+	}
+	return chunks, nil
+}
+
+// Join JSON pieces with commas between open and close
+func joinJSON(open, close string, pieces []json.RawMessage) []byte {
+	joined := []byte(open)
+	for i, piece := range pieces {
+		if i > 0 {
+			joined = append(joined, ',')
+		}
+		joined = append(joined, piece...)
+	}
+	return append(joined, close...)
+}
+
+// Find where to break a line longer than limit: after the last space within the limit, or
+// failing that at the last character boundary
+func splitPoint(line []byte, limit int) int {
+	if space := bytes.LastIndexByte(line[:limit], ' '); space > 0 {
+		return space + 1
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		return limit
+	}
+	return cut
+}
+
+// Split markdown into sections, each starting at a heading line; text before the first
+// heading is a section of its own
+func splitSections(content []byte) [][]byte {
+	var sections [][]byte
+	start := 0
+	for offset := 0; offset < len(content); {
+		end := bytes.IndexByte(content[offset:], '\n')
+		if end == -1 {
+			end = len(content)
+		} else {
+			end += offset + 1
+		}
+		if offset > start && content[offset] == '#' {
+			sections = append(sections, content[start:offset])
+			start = offset
+		}
+		offset = end
+	}
+	return append(sections, content[start:])
+}
+
+// Run fabric once per chunk, writing the outputs to stdout in order separated by a blank
+// line. A chunk whose exit code -exit-codes maps to success still contributes its output;
+// any other failure stops the run and is returned.
+func runFabricChunks(ctx context.Context, config Config, fabArgs []string, chunks [][]byte, stdout io.Writer, stderr io.Writer) error {
+	for i, chunk := range chunks {
+		var output bytes.Buffer
+		cmd := exec.CommandContext(ctx, "fabric", fabArgs...)
+		cmd.Stdin = bytes.NewReader(buildFabricInput(config, chunk))
+		cmd.Stdout = &output
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || config.ExitActions[exitErr.ExitCode()] != ExitActionSuccess {
+				return fmt.Errorf("chunk %d of %d: %w", i+1, len(chunks), err)
+			}
+		}
+
+		if i > 0 {
+			io.WriteString(stdout, "\n")
+		}
+		result := bytes.TrimRight(output.Bytes(), "\n")
+		stdout.Write(result)
+		io.WriteString(stdout, "\n")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Build a compact single-line JSON profile with the given number of positions
+func compactProfile(positions int) []byte {
+	var experience []string
+	for i := 0; i < positions; i++ {
+		experience = append(experience, fmt.Sprintf(`{"title":"Engineer %d","company":"Acme %d","description":"Built things and shipped them"}`, i, i))
+	}
+	return []byte(`{"name":"Jane Doe","headline":"Staff engineer at Acme","experience":[` + strings.Join(experience, ",") + `],"skills":["go","sql"]}`)
+}
+
+func TestSplitChunksCompactJSON(t *testing.T) {
+	content := compactProfile(20)
+	const limit = 400
+	chunks, err := splitChunks(content, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("split %d bytes into %d chunks, want several", len(content), len(chunks))
+	}
+
+	// Every chunk is valid JSON within the limit, and together they hold the whole profile
+	merged := map[string]interface{}{}
+	var experience []interface{}
+	for i, chunk := range chunks {
+		if len(chunk) > limit {
+			t.Errorf("chunk %d is %d bytes, over the limit of %d", i, len(chunk), limit)
+		}
+		var part map[string]interface{}
+		if err := json.Unmarshal(chunk, &part); err != nil {
+			t.Fatalf("chunk %d is not valid JSON: %v\n%s", i, err, chunk)
+		}
+		for name, value := range part {
+			if name == "experience" {
+				experience = append(experience, value.([]interface{})...)
+				continue
+			}
+			merged[name] = value
+		}
+	}
+	merged["experience"] = experience
+
+	var want map[string]interface{}
+	if err := json.Unmarshal(content, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("chunks merge to %v, want %v", merged, want)
+	}
+}
+
+func TestSplitChunksJSONArray(t *testing.T) {
+	content := []byte(`[{"name":"a"},{"name":"b"},{"name":"c"},{"name":"d"}]`)
+	chunks, err := splitChunks(content, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, chunk := range chunks {
+		got = append(got, string(chunk))
+	}
+	want := []string{`[{"name":"a"},{"name":"b"}]`, `[{"name":"c"},{"name":"d"}]`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitChunks = %q, want %q", got, want)
+	}
+}
+
+func TestSplitChunksUnsplittableJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"long string member", `{"name":"Jane","summary":"` + strings.Repeat("x", 100) + `"}`},
+		{"long array element", `{"experience":[{"description":"` + strings.Repeat("x", 100) + `"}]}`},
+		{"long string", `"` + strings.Repeat("x", 100) + `"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if chunks, err := splitChunks([]byte(tt.content), 50); err == nil {
+				t.Errorf("splitChunks returned %q, want an error", chunks)
+			}
+		})
+	}
+}
+
+func TestSplitChunksMarkdown(t *testing.T) {
+	content := []byte("# Jane Doe\nStaff engineer\n## Experience\nAcme\n## Skills\ngo, sql\n")
+	chunks, err := splitChunks(content, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, chunk := range chunks {
+		got = append(got, string(chunk))
+	}
+	want := []string{"# Jane Doe\nStaff engineer\n", "## Experience\nAcme\n", "## Skills\ngo, sql\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitChunks = %q, want %q", got, want)
+	}
+}
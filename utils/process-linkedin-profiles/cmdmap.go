@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// commandRule picks a fabric command for the files it matches. A rule matches either on the
+// value of a top-level field in JSON inputs, or on the input's filename.
+type commandRule struct {
+	Field    string `json:"field"`    // Top-level JSON field to classify on
+	Value    string `json:"value"`    // Value the field must hold
+	Filename string `json:"filename"` // Glob pattern matched against the base filename, e.g. exec_*.json
+	Command  string `json:"cmd"`      // Fabric command with optional arguments
+}
+
+// commandMap holds the rules of a -cmd-map file, consulted in order
+type commandMap struct {
+	Rules []commandRule `json:"rules"`
+}
+
+// Load and check a -cmd-map file such as
+//
+//	{"rules": [
+//	  {"field": "profileType", "value": "executive", "cmd": "summarize_executive_profile"},
+//	  {"filename": "recruiter_*", "cmd": "summarize_recruiter_profile -t 0.5"}
+//	]}
+func loadCommandMap(path string) (*commandMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var m commandMap
+	if err := decoder.Decode(&m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for i, rule := range m.Rules {
+		if cmdName, _ := parseFabricCommand(rule.Command); cmdName == "" {
+			return nil, fmt.Errorf("rule %d has no cmd", i+1)
+		}
+		if (rule.Field == "") == (rule.Filename == "") {
+			return nil, fmt.Errorf("rule %d must set exactly one of field or filename", i+1)
+		}
+		if rule.Filename != "" {
+			if _, err := filepath.Match(rule.Filename, ""); err != nil {
+				return nil, fmt.Errorf("rule %d has an invalid filename pattern '%s': %w", i+1, rule.Filename, err)
+			}
+		} else if rule.Value == "" {
+			return nil, fmt.Errorf("rule %d matches on field '%s' but has no value", i+1, rule.Field)
+		}
+	}
+	return &m, nil
+}
+
+// Return the command of the first rule matching the input, or fallback when none does.
+// Field rules only apply to JSON inputs whose field holds a string; filename rules are
+// skipped when there is no filename, as with stdin.
+func (m *commandMap) commandFor(fileName string, fileType string, content []byte, fallback string) string {
+	var data map[string]interface{}
+	parsed := false
+	for _, rule := range m.Rules {
+		if rule.Filename != "" {
+			if fileName == "" {
+				continue
+			}
+			if matched, _ := filepath.Match(rule.Filename, fileName); matched {
+				return rule.Command
+			}
+			continue
+		}
+
+		if fileType != FileTypeJSON {
+			continue
+		}
+		if !parsed {
+			json.Unmarshal(content, &data)
+			parsed = true
+		}
+		if value, ok := data[rule.Field].(string); ok && value == rule.Value {
+			return rule.Command
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Which inputs -embed-source appends to their output
+const (
+	EmbedSourceNone = ""
+	EmbedSourceJSON = "json"
+	EmbedSourceAll  = "all"
+)
+
+// Report whether -embed-source applies to inputs of the given type
+func embedsSource(config Config, fileType string) bool {
+	switch config.EmbedSource {
+	case EmbedSourceAll:
+		return true
+	case EmbedSourceJSON:
+		return fileType == FileTypeJSON
+	}
+	return false
+}
+
+// Append the source content to an output for -embed-source, separated by a blank line
+func embedSource(config Config, output []byte, fileType string, source []byte) []byte {
+	embedded := append(bytes.TrimRight(output, "\r\n"), "\n\n"...)
+	return append(embedded, sourceBlock(config, fileType, source)...)
+}
+
+// Render the source content for -embed-source: a horizontal rule and a "Source" heading
+// followed by a fenced block tagged with the input type. The fence is longer than any
+// backtick run in the source so the block can't close early. With -embed-minify, JSON is
+// compacted onto one line; content that doesn't parse is embedded as is.
+func sourceBlock(config Config, fileType string, source []byte) []byte {
+	if config.EmbedMinify && fileType == FileTypeJSON {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, source); err == nil {
+			source = compacted.Bytes()
+		}
+	}
+	source = bytes.TrimRight(source, "\r\n")
+
+	fence := "```"
+	for strings.Contains(string(source), fence) {
+		fence += "`"
+	}
+	language := "json"
+	if fileType == FileTypeMarkdown {
+		language = "markdown"
+	}
+
+	return []byte(fmt.Sprintf("---\n\n## Source\n\n%s%s\n%s\n%s\n", fence, language, source, fence))
+}
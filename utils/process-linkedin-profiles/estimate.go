@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// Estimate the size of what fabric would receive for each input file, without running it.
+// Each file's content is framed and pre-rendered exactly as processFile would, and tokens are
+// approximated as bytes divided by bytesPerToken.
+func estimateInput(config Config, files []string, readInput func(string) ([]byte, error), bytesPerToken float64, out io.Writer) error {
+	var totalBytes int64
+	for _, filePath := range files {
+		content, err := readInput(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s - %w", filePath, err)
+		}
+		if config.Prerender && detectFileType(filePath) == FileTypeJSON {
+			content, _ = prerenderJSON(content)
+		}
+		size := int64(len(buildFabricInput(config, content)))
+		totalBytes += size
+		fmt.Fprintf(out, "%s: %d bytes, ~%d tokens\n", filePath, size, approxTokens(size, bytesPerToken))
+	}
+	fmt.Fprintf(out, "Estimated total: %d files, %d bytes, ~%d tokens (%g bytes per token)\n",
+		len(files), totalBytes, approxTokens(totalBytes, bytesPerToken), bytesPerToken)
+	return nil
+}
+
+// Approximate a token count from a byte count, rounding up
+func approxTokens(size int64, bytesPerToken float64) int64 {
+	return int64(math.Ceil(float64(size) / bytesPerToken))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Remove the code fence around an output that is a single fenced block, such as fabric's
+// occasional ```markdown ... ``` wrapping, for -strip-fences. Output with text outside the
+// fence, or with a line inside it that would close the fence early, is left as is so
+// legitimate embedded code isn't corrupted. It reports whether a fence was removed.
+func stripFences(output []byte) ([]byte, bool) {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) < 2 {
+		return output, false
+	}
+	open := fenceOf(lines[0])
+	if open == "" {
+		return output, false
+	}
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.HasPrefix(last, open) || strings.Trim(last, open[:1]) != "" {
+		return output, false
+	}
+	inner := lines[1 : len(lines)-1]
+	for _, line := range inner {
+		if fence := fenceOf(line); fence != "" && fence[0] == open[0] && len(fence) >= len(open) && strings.TrimSpace(line) == fence {
+			return output, false
+		}
+	}
+
+	stripped := []byte(strings.Join(inner, "\n"))
+	stripped = bytes.TrimRight(stripped, "\r\n \t")
+	if len(stripped) > 0 {
+		stripped = append(stripped, '\n')
+	}
+	return stripped, true
+}
+
+// Return the run of backticks or tildes opening a fence line (at least three, indented by
+// no more than three spaces), or "" when the line isn't a fence
+func fenceOf(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || len(trimmed) < 3 || (trimmed[0] != '`' && trimmed[0] != '~') {
+		return ""
+	}
+	n := len(trimmed) - len(strings.TrimLeft(trimmed, trimmed[:1]))
+	if n < 3 {
+		return ""
+	}
+	return trimmed[:n]
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Parse a comma-separated list of -include or -exclude glob patterns, keeping their order
+func parseGlobList(spec string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern '%s': %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// Match a discovered file against a glob. Patterns without a slash match the filename;
+// patterns with one match the path relative to the input folder, using forward slashes.
+func matchGlob(config Config, pattern string, filePath string) bool {
+	name := filepath.Base(filePath)
+	if strings.Contains(pattern, "/") {
+		name = filepath.ToSlash(filePath)
+		if relative, err := filepath.Rel(config.InputFolder, filePath); err == nil && !strings.HasPrefix(relative, "..") {
+			name = filepath.ToSlash(relative)
+		}
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// Explain why -include or -exclude leaves a discovered file out, or return "" when it is
+// processed. Patterns are tried in order: a file must match an include pattern, when any are
+// given, and is dropped by the first exclude pattern it matches.
+func filterReason(config Config, filePath string) string {
+	if len(config.Include) > 0 {
+		included := false
+		for _, pattern := range config.Include {
+			if matchGlob(config, pattern, filePath) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return "matches no -include pattern"
+		}
+	}
+	for _, pattern := range config.Exclude {
+		if matchGlob(config, pattern, filePath) {
+			return fmt.Sprintf("matches -exclude pattern '%s'", pattern)
+		}
+	}
+	return ""
+}
+
+// Wrap a discovery callback so only files passing -include and -exclude reach it
+func filterFiles(config Config, found func(filePath string)) func(filePath string) {
+	if len(config.Include) == 0 && len(config.Exclude) == 0 {
+		return found
+	}
+	return func(filePath string) {
+		if reason := filterReason(config, filePath); reason != "" {
+			if config.Verbose {
+				fmt.Fprintf(console, "Filtered out %s: %s\n", filePath, reason)
+			}
+			return
+		}
+		found(filePath)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingLog writes to the log file and rotates it once it would grow past maxSize:
+// profile_process.log becomes profile_process.log.1, older logs shift up to .2, .3, ...
+// and only keep of them are kept.
+type rotatingLog struct {
+	mutex   sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64
+	keep    int
+}
+
+// Wrap an open, freshly initialized log file for rotation
+func newRotatingLog(file *os.File, path string, maxSize int64, keep int) *rotatingLog {
+	return &rotatingLog{path: path, file: file, maxSize: maxSize, keep: keep}
+}
+
+func (r *rotatingLog) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// A failed rotation keeps appending to the current file rather than losing log lines
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Failed to rotate log file %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Shift the old logs up by one, dropping the oldest, and start a fresh log file
+func (r *rotatingLog) rotate() error {
+	r.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.keep))
+	for i := r.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	renameErr := os.Rename(r.path, r.path+".1")
+
+	// Reopen the log either way, so writes can continue after a failed rename
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.size = 0
+	if renameErr != nil {
+		if info, statErr := file.Stat(); statErr == nil {
+			r.size = info.Size()
+		}
+		return renameErr
+	}
+	return nil
+}
+
+// Close the current log file
+func (r *rotatingLog) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
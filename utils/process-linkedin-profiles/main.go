@@ -1,15 +1,30 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
+
+	"github.com/branexp/linkedin-data-enrichment/internal/discovery"
+	"github.com/branexp/linkedin-data-enrichment/internal/manifest"
+	"github.com/fsnotify/fsnotify"
 )
 
 // File types supported by the processor
@@ -19,142 +34,807 @@ const (
 	FileTypeUnknown  = "unknown"
 )
 
+// Policies for handling an output file that already exists
+const (
+	OnExistsOverwrite = "overwrite"
+	OnExistsSkip      = "skip"
+	OnExistsVersion   = "version"
+	OnExistsFail      = "fail"
+)
+
+// Outcomes a fabric exit code can be mapped to with -exit-codes
+const (
+	ExitActionSuccess = "success"
+	ExitActionSkip    = "skip"
+	ExitActionFail    = "fail"
+)
+
+// console receives progress and per-file output; -summary-only discards it so a cron run
+// prints only its final report
+var console io.Writer = os.Stdout
+
 // Configuration struct to hold settings
 type Config struct {
-	InputFolder   string
-	OutputFolder  string
-	LogFolder     string
-	LogFile       string
-	MaxWorkers    int
-	Verbose       bool
-	FabricCommand string // Field for fabric command with optional arguments
+	InputFolder     string
+	OutputFolder    string
+	OutputJSON      string // Optional output folder override for JSON inputs
+	OutputMD        string // Optional output folder override for markdown inputs
+	LogFolder       string
+	LogFile         string
+	LogMaxSize      int64 // Rotate the log file once it would grow past this many bytes; 0 disables rotation
+	LogKeep         int   // Rotated log files kept with LogMaxSize
+	MaxWorkers      int
+	Ramp            time.Duration // Period over which worker slots are released at startup
+	Verbose         bool
+	FabricCommand   string             // Field for fabric command with optional arguments
+	CommandMap      *commandMap        // Per-file fabric commands from -cmd-map; unmatched files use FabricCommand
+	InputPrefix     string             // Text written to fabric's stdin before the file content
+	InputSuffix     string             // Text written to fabric's stdin after the file content
+	Prerender       bool               // Convert JSON profiles to markdown before piping them to fabric
+	Stdin           bool               // Process stdin as a single document and write the result to stdout
+	StdinType       string             // File type of the stdin document (json or md)
+	Watch           bool               // Keep running and process new files as they appear
+	WatchDebounce   time.Duration      // Quiet period after the last write before a watched file is processed
+	WatchQueue      int                // Files detected while watching that may wait for a worker before new ones are dropped
+	OnExists        string             // Policy when the output file already exists
+	FailFast        bool               // Stop dispatching and cancel in-flight files after the first failure
+	EmptyIsFailed   bool               // Count a successful fabric run with an empty output file as failed
+	MinOutputBytes  int64              // Smallest valid output file; smaller outputs count as failed
+	RequireContains []string           // Text every valid output file must contain
+	MarkdownMarkers []string           // Text one of which a markdown input must contain to be processed as a profile
+	DeleteInvalid   bool               // Remove output files that fail validation
+	ExitActions     map[int]string     // Outcome for specific non-zero fabric exit codes
+	MetricsFile     string             // Prometheus textfile written when the run completes
+	FailedOut       string             // File listing the paths of failed files, one per line
+	Manifest        string             // jsonl-splitter manifest listing the files to process
+	PriorManifest   string             // Manifest from the previous run; unchanged entries are skipped
+	CaptureStdout   bool               // Read fabric's output from stdout and write the output file ourselves
+	Deadline        time.Duration      // Stop dispatching new files once the run has taken this long
+	DeadlineGrace   time.Duration      // Time in-flight files get to finish after the deadline
+	OutputTemplate  *template.Template // Output path relative to the output folder; nil writes <base>.md
+	RunStarted      time.Time          // Date available to the output template
+	SummaryOnly     bool               // Suppress per-file output and print one line, or failure details, at the end
+	ChunkBytes      int                // Run fabric on chunks of at most this many bytes of oversized content; 0 disables chunking
+	Include         []string           // Glob patterns a discovered file must match one of; empty includes every file
+	Exclude         []string           // Glob patterns leaving matching files out, applied after Include
+	StripFences     bool               // Unwrap outputs that are a single fenced code block
+	EmbedSource     string             // Inputs whose source is appended to their output: json, all, or "" for none
+	EmbedMinify     bool               // Compact embedded JSON onto one line
+	BatchSize       int                // Files sent to fabric together in one run; 0 or 1 runs fabric once per file
+	Batcher         *fabricBatcher     // Groups files into fabric runs when BatchSize is above 1
+}
+
+// versionedOutputs hands out collision-safe versioned output paths (name.v2.md, name.v3.md, ...),
+// remembering paths claimed by concurrent workers that haven't been written yet
+type versionedOutputs struct {
+	mutex    sync.Mutex
+	reserved map[string]bool
+}
+
+var outputVersions = &versionedOutputs{reserved: make(map[string]bool)}
+
+// Reserve the first free versioned path for the given output path
+func (v *versionedOutputs) next(outputFilePath string) string {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	ext := filepath.Ext(outputFilePath)
+	base := strings.TrimSuffix(outputFilePath, ext)
+	for version := 2; ; version++ {
+		candidate := fmt.Sprintf("%s.v%d%s", base, version, ext)
+		if v.reserved[candidate] {
+			continue
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			continue
+		}
+		v.reserved[candidate] = true
+		return candidate
+	}
 }
 
-// ProcessingStats tracks statistics about the processing
+// ProcessingStats tracks statistics about the processing. The counters are atomic so workers
+// can update them and progress can be read without taking a lock; mu only guards the
+// durations, per-command counts and failed paths used when rendering summaries.
 type ProcessingStats struct {
-	Total      int
-	Successful int
-	Failed     int
-	Skipped    int
-	JSONFiles  int
-	MDFiles    int
+	Total      atomic.Int64
+	Successful atomic.Int64
+	Failed     atomic.Int64
+	Skipped    atomic.Int64
+	JSONFiles  atomic.Int64
+	MDFiles    atomic.Int64
+
+	mu          sync.Mutex
+	Durations   []time.Duration   // Wall-clock time of each fabric call (recorded in verbose mode)
+	ByCommand   map[string]int    // Successful files per fabric command
+	FailedFiles []string          // Input paths of failed files, in the order they failed
+	FailReasons map[string]string // Error message for each failed input path
 }
 
 // Initialize a new ProcessingStats
 func newProcessingStats() *ProcessingStats {
-	return &ProcessingStats{}
+	return &ProcessingStats{ByCommand: make(map[string]int), FailReasons: make(map[string]string)}
 }
 
-// Increment the successful count and file type count
-func (s *ProcessingStats) incrementSuccessful(mutex *sync.Mutex, fileType string) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	s.Successful++
+// Increment the successful count, file type count and count for the fabric command used
+func (s *ProcessingStats) incrementSuccessful(fileType string, command string) {
+	s.Successful.Add(1)
 	if fileType == FileTypeJSON {
-		s.JSONFiles++
+		s.JSONFiles.Add(1)
 	} else if fileType == FileTypeMarkdown {
-		s.MDFiles++
+		s.MDFiles.Add(1)
 	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ByCommand[command]++
 }
 
-// Increment the failed count
-func (s *ProcessingStats) incrementFailed(mutex *sync.Mutex) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	s.Failed++
+// Increment the failed count, recording the file and why it failed
+func (s *ProcessingStats) incrementFailed(filePath string, reason string) {
+	s.Failed.Add(1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FailedFiles = append(s.FailedFiles, filePath)
+	s.FailReasons[filePath] = reason
+}
+
+// Report whether any file has failed so far
+func (s *ProcessingStats) hasFailures() bool {
+	return s.Failed.Load() > 0
 }
 
 // Increment the skipped count
-func (s *ProcessingStats) incrementSkipped(mutex *sync.Mutex) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	s.Skipped++
+func (s *ProcessingStats) incrementSkipped() {
+	s.Skipped.Add(1)
+}
+
+// Record the duration of a single fabric call
+func (s *ProcessingStats) recordDuration(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Durations = append(s.Durations, d)
 }
 
 // Set the total count
 func (s *ProcessingStats) setTotal(total int) {
-	s.Total = total
+	s.Total.Store(int64(total))
 }
 
 // Get a summary string
 func (s *ProcessingStats) getSummary() string {
-	return fmt.Sprintf(
+	summary := fmt.Sprintf(
 		"Total: %d, Successful: %d (JSON: %d, MD: %d), Failed: %d, Skipped: %d",
-		s.Total, s.Successful, s.JSONFiles, s.MDFiles, s.Failed, s.Skipped,
+		s.Total.Load(), s.Successful.Load(), s.JSONFiles.Load(), s.MDFiles.Load(), s.Failed.Load(), s.Skipped.Load(),
+	)
+	if breakdown := s.getCommandBreakdown(); breakdown != "" {
+		summary += ", By command: " + breakdown
+	}
+	return summary
+}
+
+// Get the per-command success counts as "cmd=count" pairs sorted by command
+func (s *ProcessingStats) getCommandBreakdown() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	commands := make([]string, 0, len(s.ByCommand))
+	for command := range s.ByCommand {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	parts := make([]string, 0, len(commands))
+	for _, command := range commands {
+		parts = append(parts, fmt.Sprintf("'%s'=%d", command, s.ByCommand[command]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Get a summary of fabric call durations (min/max/avg/p95), or an empty string if none were recorded
+func (s *ProcessingStats) getTimingSummary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.Durations) == 0 {
+		return ""
+	}
+
+	sorted := make([]time.Duration, len(s.Durations))
+	copy(sorted, s.Durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	avg := total / time.Duration(len(sorted))
+
+	// Nearest-rank 95th percentile
+	p95Index := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	p95 := sorted[p95Index]
+
+	return fmt.Sprintf(
+		"Fabric call timing: min %s, max %s, avg %s, p95 %s",
+		formatDuration(sorted[0]), formatDuration(sorted[len(sorted)-1]), formatDuration(avg), formatDuration(p95),
 	)
 }
 
+// Format a duration in seconds with one decimal place (e.g. 3.4s)
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
 func main() {
 	// Define command-line flags
 	config := Config{}
-	flag.StringVar(&config.InputFolder, "input", "data/test/split", "Path to the folder containing input JSON and markdown files")
+	flag.StringVar(&config.InputFolder, "input", "data/test/split", "Path to the folder, or zip archive, containing input JSON and markdown files")
 	flag.StringVar(&config.OutputFolder, "output", "data/test/profile", "Path to the folder where processed profiles will be saved")
+	flag.StringVar(&config.OutputJSON, "output-json", "", "Output folder for summaries of JSON inputs (overrides -output)")
+	flag.StringVar(&config.OutputMD, "output-md", "", "Output folder for summaries of markdown inputs (overrides -output)")
 	flag.StringVar(&config.LogFolder, "logdir", "logs", "Folder for storing log files")
+	flag.Int64Var(&config.LogMaxSize, "log-max-size", 0, "Rotate the log file to profile_process.log.1 once it would grow past this many bytes (0 disables rotation)")
+	flag.IntVar(&config.LogKeep, "log-keep", 3, "Number of rotated log files kept with -log-max-size")
 	flag.IntVar(&config.MaxWorkers, "workers", 5, "Maximum number of concurrent workers")
+	flag.DurationVar(&config.Ramp, "ramp", 0, "Release worker slots gradually over this period at startup (e.g. 30s) instead of starting all -workers at once")
 	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
 	flag.StringVar(&config.FabricCommand, "fabric-cmd", "summarize_linkedin_profile",
 		"Fabric command with optional arguments (e.g., 'summarize_linkedin_profile -t 0.7')")
+	cmdMapPath := flag.String("cmd-map", "", "JSON file of rules choosing a fabric command per file by a JSON field value or filename pattern (unmatched files use -fabric-cmd)")
+	flag.StringVar(&config.InputPrefix, "input-prefix", "", "Text written to fabric's stdin before each file's content")
+	flag.StringVar(&config.InputSuffix, "input-suffix", "", "Text written to fabric's stdin after each file's content")
+	flag.BoolVar(&config.Prerender, "prerender", false, "Convert JSON profiles to markdown (name, headline, experience, ...) before piping them to fabric")
+	flag.BoolVar(&config.Stdin, "stdin", false, "Process stdin as a single document and write the result to stdout")
+	flag.StringVar(&config.StdinType, "stdin-type", FileTypeJSON, "File type of the stdin document in -stdin mode (json or md)")
+	flag.BoolVar(&config.Watch, "watch", false, "After the initial batch, keep running and process new files as they appear")
+	flag.DurationVar(&config.WatchDebounce, "watch-debounce", 2*time.Second, "Quiet period after the last write before a watched file is processed")
+	flag.IntVar(&config.WatchQueue, "watch-queue", 1000, "Maximum number of watched files waiting for a worker; files detected while it is full are dropped with a warning")
+	flag.StringVar(&config.OnExists, "on-exists", OnExistsOverwrite, "What to do when an output file already exists: overwrite, skip, version or fail")
+	flag.BoolVar(&config.FailFast, "fail-fast", false, "Stop at the first failed file, cancelling in-flight files, and print the partial summary")
+	flag.BoolVar(&config.EmptyIsFailed, "treat-empty-as-failure", false, "Count a fabric run that leaves an empty or missing output file as failed")
+	flag.Int64Var(&config.MinOutputBytes, "min-output-bytes", 0, "Count a file as failed when its output is smaller than this many bytes (0 disables the check)")
+	var requireContains stringList
+	flag.Var(&requireContains, "require-contains", "Text the output must contain, such as a section header, or the file counts as failed (repeatable)")
+	var markdownMarkers stringList
+	flag.Var(&markdownMarkers, "md-marker", "Text marking a markdown input as a profile, such as '## Experience'; markdown files containing none of the markers are skipped (repeatable)")
+	flag.BoolVar(&config.DeleteInvalid, "delete-invalid-output", false, "Delete output files that fail -min-output-bytes or -require-contains")
+	exitCodes := flag.String("exit-codes", "", "Comma-separated code=action pairs classifying non-zero fabric exit codes as success, skip or fail (e.g. '2=skip,4=success')")
+	flag.StringVar(&config.FailedOut, "failed-out", "", "Write the path of every failed file to this file, one per line, when the run completes")
+	flag.StringVar(&config.MetricsFile, "metrics-file", "", "Write Prometheus textfile metrics for the run to this path on completion")
+	flag.StringVar(&config.Manifest, "manifest", "", "Process the files listed in this jsonl-splitter manifest instead of scanning the input folder")
+	flag.StringVar(&config.PriorManifest, "prior-manifest", "", "Manifest from the previous run; with -manifest, only new or changed entries are processed")
+	flag.BoolVar(&config.CaptureStdout, "capture-stdout", false, "Capture fabric's stdout and write each output file atomically instead of passing -o to fabric")
+	flag.DurationVar(&config.Deadline, "deadline", 0, "Stop dispatching new files after this long (e.g. 2h); 0 means no deadline")
+	flag.DurationVar(&config.DeadlineGrace, "deadline-grace", time.Minute, "Time in-flight files get to finish after -deadline before they are cancelled")
+	outputTemplate := flag.String("output-template", "", "Template for each output path relative to the output folder, using {{.Base}}, {{.Type}}, {{.Date}} and {{.Ext}} (e.g. '{{.Date.Format \"2006-01\"}}/{{.Type}}/{{.Base}}.md')")
+	estimate := flag.Bool("estimate", false, "Print the approximate size in bytes and tokens of every input file and exit without running fabric")
+	bytesPerToken := flag.Float64("bytes-per-token", 4, "Bytes per token assumed by -estimate")
+	sample := flag.Int("sample", 0, "Run fabric on the first N input files one at a time, print their outputs and exit without writing them")
+	plan := flag.Bool("plan", false, "List whether each input file would be processed, skipped or fail, with its output path, and exit without running fabric")
+	keepSamples := flag.Bool("keep-samples", false, "Also write the -sample outputs to their usual output paths")
+	flag.IntVar(&config.BatchSize, "batch-size", 0, "Send up to this many files that use the same fabric command to one fabric run, with delimiters, and split the output back into one file each; files whose batch can't be split are run on their own (0 or 1 disables batching)")
+	flag.IntVar(&config.ChunkBytes, "chunk-bytes", 0, "Split content larger than this many bytes into chunks at markdown sections, or between JSON members and array elements, run fabric on each and concatenate the outputs (0 disables chunking)")
+	include := flag.String("include", "", "Comma-separated glob patterns; only discovered files whose name matches one are processed (e.g. 'acme-*.json,*.md')")
+	exclude := flag.String("exclude", "", "Comma-separated glob patterns; discovered files whose name matches one are left out (e.g. '*-test.json')")
+	flag.BoolVar(&config.StripFences, "strip-fences", false, "Remove the code fence around an output that is entirely one fenced block (e.g. when fabric wraps its reply in a markdown fence)")
+	flag.StringVar(&config.EmbedSource, "embed-source", EmbedSourceNone, "Append each input's source content to its output as a fenced block: json (JSON inputs only) or all")
+	flag.BoolVar(&config.EmbedMinify, "embed-minify", false, "Compact JSON embedded by -embed-source onto one line")
+	flag.BoolVar(&config.SummaryOnly, "summary-only", false, "Suppress per-file output and print one summary line, or the failed files' errors if any failed (for cron email)")
 	flag.Parse()
+	runStart := time.Now()
+	config.RequireContains = requireContains
+	config.MarkdownMarkers = markdownMarkers
+	config.RunStarted = runStart
+
+	if *outputTemplate != "" {
+		tmpl, err := parseOutputTemplate(*outputTemplate)
+		if err != nil {
+			fmt.Printf("Invalid -output-template: %v\n", err)
+			os.Exit(1)
+		}
+		config.OutputTemplate = tmpl
+	}
+
+	if *cmdMapPath != "" {
+		commands, err := loadCommandMap(*cmdMapPath)
+		if err != nil {
+			fmt.Printf("Invalid -cmd-map: %v\n", err)
+			os.Exit(1)
+		}
+		config.CommandMap = commands
+	}
+
+	exitActions, err := parseExitCodes(*exitCodes)
+	if err != nil {
+		fmt.Printf("Invalid -exit-codes: %v\n", err)
+		os.Exit(1)
+	}
+	config.ExitActions = exitActions
+
+	if config.Include, err = parseGlobList(*include); err != nil {
+		fmt.Printf("Invalid -include: %v\n", err)
+		os.Exit(1)
+	}
+	if config.Exclude, err = parseGlobList(*exclude); err != nil {
+		fmt.Printf("Invalid -exclude: %v\n", err)
+		os.Exit(1)
+	}
+
+	if config.LogMaxSize < 0 {
+		fmt.Printf("Invalid -log-max-size: must not be negative, got %d\n", config.LogMaxSize)
+		os.Exit(1)
+	}
+	if config.LogMaxSize > 0 && config.LogKeep < 1 {
+		fmt.Printf("Invalid -log-keep: must be at least 1, got %d\n", config.LogKeep)
+		os.Exit(1)
+	}
+	if config.ChunkBytes < 0 {
+		fmt.Printf("Invalid -chunk-bytes: must not be negative, got %d\n", config.ChunkBytes)
+		os.Exit(1)
+	}
+	if config.BatchSize < 0 {
+		fmt.Printf("Invalid -batch-size: must not be negative, got %d\n", config.BatchSize)
+		os.Exit(1)
+	}
+	if config.Ramp < 0 {
+		fmt.Printf("Invalid -ramp: must not be negative, got %s\n", config.Ramp)
+		os.Exit(1)
+	}
+	if config.SummaryOnly && config.Verbose {
+		fmt.Println("Invalid -summary-only: can't be combined with -verbose")
+		os.Exit(1)
+	}
+	if config.PriorManifest != "" && config.Manifest == "" {
+		fmt.Println("Invalid -prior-manifest: requires -manifest")
+		os.Exit(1)
+	}
+	if config.Watch && config.WatchQueue < 1 {
+		fmt.Printf("Invalid -watch-queue: must be at least 1, got %d\n", config.WatchQueue)
+		os.Exit(1)
+	}
+	if config.Watch && isZipInput(config.InputFolder) {
+		fmt.Println("Invalid -watch: a zip archive input can't be watched")
+		os.Exit(1)
+	}
+
+	switch config.OnExists {
+	case OnExistsOverwrite, OnExistsSkip, OnExistsVersion, OnExistsFail:
+	default:
+		fmt.Printf("Invalid -on-exists policy '%s' (use overwrite, skip, version or fail)\n", config.OnExists)
+		os.Exit(1)
+	}
+
+	switch config.EmbedSource {
+	case EmbedSourceNone, EmbedSourceJSON, EmbedSourceAll:
+	default:
+		fmt.Printf("Invalid -embed-source '%s' (use json or all)\n", config.EmbedSource)
+		os.Exit(1)
+	}
+	if config.EmbedMinify && config.EmbedSource == EmbedSourceNone {
+		fmt.Println("Invalid -embed-minify: requires -embed-source")
+		os.Exit(1)
+	}
+
+	// Single-document mode bypasses discovery, the worker pool and file logging
+	if config.Stdin {
+		if err := processStdin(config, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Estimate the run's input size without invoking fabric or creating any output, plan
+	// what a run would do, or preview fabric's output for the first few files
+	if *sample < 0 {
+		fmt.Printf("Invalid -sample: must not be negative, got %d\n", *sample)
+		os.Exit(1)
+	}
+	if *keepSamples && *sample == 0 {
+		fmt.Println("Invalid -keep-samples: requires -sample")
+		os.Exit(1)
+	}
+	if *estimate && *sample > 0 {
+		fmt.Println("Invalid -sample: can't be combined with -estimate")
+		os.Exit(1)
+	}
+	if *plan && (*estimate || *sample > 0) {
+		fmt.Println("Invalid -plan: can't be combined with -estimate or -sample")
+		os.Exit(1)
+	}
+	if *estimate || *sample > 0 || *plan {
+		if *estimate && *bytesPerToken <= 0 {
+			fmt.Printf("Invalid -bytes-per-token: must be positive, got %g\n", *bytesPerToken)
+			os.Exit(1)
+		}
+		var files []string
+		readInput := os.ReadFile
+		collect := func(filePath string) { files = append(files, filePath) }
+		if !*plan {
+			collect = filterFiles(config, collect) // A plan lists filtered files too
+		}
+		var archive *zipInput
+		if isZipInput(config.InputFolder) {
+			archive, err = openZipInput(config.InputFolder)
+			if err != nil {
+				fmt.Printf("ERROR: Failed to open input archive %s: %v\n", config.InputFolder, err)
+				os.Exit(1)
+			}
+			defer archive.Close()
+			readInput = archive.readFile
+		}
+		if config.Manifest != "" {
+			err = findManifestFiles(config, collect, log.New(io.Discard, "", 0))
+		} else if archive != nil {
+			archive.findInputFiles(collect)
+		} else {
+			err = discovery.FindInputFiles(config.InputFolder, collect)
+		}
+		if err != nil {
+			fmt.Printf("ERROR: Failed to scan input folder %s: %v\n", config.InputFolder, err)
+			os.Exit(1)
+		}
+		if *plan {
+			planFiles(config, files, readInput, os.Stdout)
+			return
+		}
+		if *sample > 0 {
+			if len(files) > *sample {
+				files = files[:*sample]
+			}
+			if failed := sampleFiles(config, files, readInput, *keepSamples, os.Stdout); failed > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		if err := estimateInput(config, files, readInput, *bytesPerToken, os.Stdout); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Per-file output is dropped; the report printed at the end replaces it
+	if config.SummaryOnly {
+		console = io.Discard
+	}
 
 	// Set log file path
 	config.LogFile = filepath.Join(config.LogFolder, "profile_process.log")
 
 	// Ensure directories exist
 	ensureDirectoryExists(config.OutputFolder)
+	if config.OutputJSON != "" {
+		ensureDirectoryExists(config.OutputJSON)
+	}
+	if config.OutputMD != "" {
+		ensureDirectoryExists(config.OutputMD)
+	}
 	ensureDirectoryExists(config.LogFolder)
 
 	// Initialize log file
 	logFile := initLogFile(config.LogFile)
-	defer logFile.Close()
+	var logOutput io.WriteCloser = logFile
+	if config.LogMaxSize > 0 {
+		logOutput = newRotatingLog(logFile, config.LogFile, config.LogMaxSize, config.LogKeep)
+	}
+	defer logOutput.Close()
 
 	// Set up logger
-	logger := log.New(logFile, "", 0)
+	logger := log.New(logOutput, "", 0)
 
 	// Log the configuration
 	logAndPrint(logger, fmt.Sprintf("INFO: Using fabric command: %s", config.FabricCommand), config.Verbose)
+	if config.CommandMap != nil {
+		logAndPrint(logger, fmt.Sprintf("INFO: Choosing fabric commands with %d rules from %s", len(config.CommandMap.Rules), *cmdMapPath), config.Verbose)
+	}
+
+	// Create worker pool for parallel processing
+	var wg sync.WaitGroup
+	var mutex sync.Mutex // For thread-safe logging
+	stats := newProcessingStats()
+
+	// Batched files wait for their batch while holding a token, so there are enough tokens
+	// to fill a batch per worker; the batches themselves are limited to -workers fabric runs
+	tokens := config.MaxWorkers
+	if config.BatchSize > 1 {
+		tokens *= config.BatchSize
+	}
+	semaphore := make(chan struct{}, tokens)
+
+	// Input files come from the folder, or straight from a zip archive
+	readInput := os.ReadFile
+	var archive *zipInput
+	if isZipInput(config.InputFolder) {
+		var err error
+		archive, err = openZipInput(config.InputFolder)
+		if err != nil {
+			message := fmt.Sprintf("ERROR: Failed to open input archive %s: %v", config.InputFolder, err)
+			logAndPrint(logger, message, config.Verbose)
+			os.Exit(1)
+		}
+		defer archive.Close()
+		readInput = archive.readFile
+		logAndPrint(logger, fmt.Sprintf("INFO: Reading input files from archive %s", config.InputFolder), config.Verbose)
+	}
+
+	// In watch mode, start watching before discovery so files created in between aren't missed
+	var watcher *fsnotify.Watcher
+	if config.Watch {
+		var err error
+		watcher, err = fsnotify.NewWatcher()
+		if err == nil {
+			err = watcher.Add(config.InputFolder)
+		}
+		if err != nil {
+			message := fmt.Sprintf("ERROR: Failed to watch input folder %s: %v", config.InputFolder, err)
+			logAndPrint(logger, message, config.Verbose)
+			os.Exit(1)
+		}
+		defer watcher.Close()
+	}
+
+	// Shared by all workers; cancelled on the first failure with -fail-fast. With -deadline,
+	// dispatching stops when the deadline passes and in-flight files get the grace period to
+	// finish before the shared context is cancelled too.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dispatchCtx := ctx
+	if config.Deadline > 0 {
+		var stopDispatch context.CancelFunc
+		dispatchCtx, stopDispatch = context.WithTimeout(ctx, config.Deadline)
+		defer stopDispatch()
+		graceTimer := time.AfterFunc(config.Deadline+config.DeadlineGrace, cancel)
+		defer graceTimer.Stop()
+	}
+
+	// Ease into the backend's rate limits by adding workers one at a time. With batching
+	// the ramp applies to the fabric runs batches share.
+	if config.BatchSize > 1 {
+		config.Batcher = newFabricBatcher(ctx, config)
+		rampWorkers(ctx, config.Batcher.slots, config.MaxWorkers, config.Ramp)
+	} else {
+		rampWorkers(ctx, semaphore, config.MaxWorkers, config.Ramp)
+	}
+
+	// Hand a file to the pool; acquiring a token blocks the caller while all workers are busy.
+	// When watching, each path is only dispatched once. Nothing more is dispatched after a
+	// -fail-fast abort, and files found after the deadline are counted as remaining.
+	var dispatchMutex sync.Mutex
+	dispatched := make(map[string]bool)
+	discovered := 0
+	remaining := 0
+	countRemaining := func() {
+		dispatchMutex.Lock()
+		remaining++
+		dispatchMutex.Unlock()
+	}
+	// A watched file is dispatched while watching is active; one still waiting for a token
+	// when watching stops isn't started or counted, and false is returned so the watch
+	// queue holds it back.
+	dispatchWhile := func(watching context.Context, filePath string) bool {
+		if ctx.Err() != nil {
+			return true
+		}
+		dispatchMutex.Lock()
+		if config.Watch {
+			if dispatched[filePath] {
+				dispatchMutex.Unlock()
+				return true
+			}
+			dispatched[filePath] = true
+		}
+		discovered++
+		dispatchMutex.Unlock()
+		hold := func() bool {
+			dispatchMutex.Lock()
+			delete(dispatched, filePath)
+			discovered--
+			dispatchMutex.Unlock()
+			return false
+		}
 
-	// Get all input files (JSON and markdown)
-	inputFiles, err := findInputFiles(config.InputFolder)
+		// Acquire a token, unless dispatching or watching stops while waiting for one
+		select {
+		case semaphore <- struct{}{}:
+		case <-dispatchCtx.Done():
+			countRemaining()
+			return true
+		case <-watching.Done():
+			return hold()
+		}
+		if dispatchCtx.Err() == nil && watching.Err() != nil {
+			<-semaphore // Watching stopped as the token was acquired
+			return hold()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }() // Release the token when done
+			if dispatchCtx.Err() != nil {
+				countRemaining() // Dispatching stopped before this file started
+				return
+			}
+			processFile(ctx, filePath, readInput, config, logger, &mutex, stats)
+			if config.FailFast && stats.hasFailures() {
+				cancel()
+			}
+		}()
+		return true
+	}
+	dispatch := func(filePath string) { dispatchWhile(dispatchCtx, filePath) }
+
+	// Stream input files (JSON and markdown) into the pool as they are discovered, or
+	// take them from the manifest when running incrementally
+	dispatch = filterFiles(config, dispatch)
+	if config.Manifest != "" {
+		err = findManifestFiles(config, dispatch, logger)
+	} else if archive != nil {
+		archive.findInputFiles(dispatch)
+	} else {
+		err = discovery.FindInputFiles(config.InputFolder, dispatch)
+	}
 	if err != nil {
+		wg.Wait()
 		message := fmt.Sprintf("ERROR: Failed to read input files: %v", err)
 		logAndPrint(logger, message, config.Verbose)
 		os.Exit(1)
 	}
 
-	// Check if any files were found
-	if len(inputFiles) == 0 {
+	// Discovery is complete, so the total is now known
+	dispatchMutex.Lock()
+	initialCount := discovered
+	dispatchMutex.Unlock()
+	stats.setTotal(initialCount)
+	if initialCount == 0 && !config.Watch {
 		message := fmt.Sprintf("WARNING: No JSON or markdown files found in %s", config.InputFolder)
+		if config.Manifest != "" {
+			message = fmt.Sprintf("INFO: No new or changed files in manifest %s", config.Manifest)
+		}
 		logAndPrint(logger, message, config.Verbose)
+		writeMetrics(config, stats, time.Since(runStart), logger)
+		writeFailedList(config, stats, logger)
+		if config.SummaryOnly {
+			printSummaryReport(stats, time.Since(runStart), nil)
+		}
 		os.Exit(0)
 	} else {
-		message := fmt.Sprintf("INFO: Found %d files to process", len(inputFiles))
+		message := fmt.Sprintf("INFO: Found %d files to process", initialCount)
 		logAndPrint(logger, message, config.Verbose)
 	}
 
-	// Create worker pool for parallel processing
-	var wg sync.WaitGroup
-	var mutex sync.Mutex // For thread-safe logging
-	semaphore := make(chan struct{}, config.MaxWorkers)
-	stats := newProcessingStats()
-	stats.setTotal(len(inputFiles))
+	// Keep processing new files until interrupted. New files wait in a bounded queue for the
+	// same worker tokens as the initial batch, so a large drop can't pile up goroutines.
+	if config.Watch {
+		watchCtx, stop := signal.NotifyContext(dispatchCtx, os.Interrupt, syscall.SIGTERM)
+		logAndPrint(logger, fmt.Sprintf("INFO: Watching %s for new files (press Ctrl+C to stop)", config.InputFolder), config.Verbose)
+		queue := newWatchQueue(watchCtx, config.WatchQueue, dispatchWhile, logger, &mutex)
+		watchInputFolder(watchCtx, watcher, config.WatchDebounce, filterFiles(config, queue.enqueue), logger, &mutex)
+		stop()
+		logAndPrint(logger, "INFO: Stopped watching, waiting for in-flight files", config.Verbose)
+		queue.Close()
 
-	// Process each file
-	for _, file := range inputFiles {
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire a token
-		go func(filePath string) {
-			defer wg.Done()
-			defer func() { <-semaphore }() // Release the token when done
-			processFile(filePath, config, logger, &mutex, stats)
-		}(file)
+		dispatchMutex.Lock()
+		stats.setTotal(discovered)
+		dispatchMutex.Unlock()
 	}
 
 	// Wait for all goroutines to finish
 	wg.Wait()
 
+	// Files discovered before the abort are the only ones counted
+	var warnings []string
+	aborted := config.FailFast && stats.hasFailures()
+	if aborted {
+		dispatchMutex.Lock()
+		stats.setTotal(discovered)
+		message := fmt.Sprintf("WARNING: Stopped after the first failure (-fail-fast); summary is partial, %d files not started", remaining)
+		dispatchMutex.Unlock()
+		logAndPrint(logger, message, config.Verbose)
+		warnings = append(warnings, message)
+	}
+	if errors.Is(dispatchCtx.Err(), context.DeadlineExceeded) {
+		dispatchMutex.Lock()
+		message := fmt.Sprintf("WARNING: Deadline of %s reached; %d files processed, %d remaining", config.Deadline, discovered-remaining, remaining)
+		dispatchMutex.Unlock()
+		logAndPrint(logger, message, config.Verbose)
+		warnings = append(warnings, message)
+	}
+
 	// Log completion with statistics
 	completionMsg := fmt.Sprintf("INFO: Processing completed. %s", stats.getSummary())
 	logAndPrint(logger, completionMsg, config.Verbose)
+	if timingMsg := stats.getTimingSummary(); timingMsg != "" {
+		logAndPrint(logger, "INFO: "+timingMsg, config.Verbose)
+	}
+	writeMetrics(config, stats, time.Since(runStart), logger)
+	writeFailedList(config, stats, logger)
+	if config.SummaryOnly {
+		printSummaryReport(stats, time.Since(runStart), warnings)
+	}
+	if aborted {
+		os.Exit(1)
+	}
+}
+
+// Parse an -exit-codes spec such as "2=skip,4=success" into a map of exit code to action
+func parseExitCodes(spec string) (map[int]string, error) {
+	actions := make(map[int]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		codeText, action, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected code=action, got '%s'", pair)
+		}
+		code, err := strconv.Atoi(strings.TrimSpace(codeText))
+		if err != nil || code <= 0 {
+			return nil, fmt.Errorf("invalid exit code '%s'", codeText)
+		}
+		action = strings.TrimSpace(action)
+		switch action {
+		case ExitActionSuccess, ExitActionSkip, ExitActionFail:
+			actions[code] = action
+		default:
+			return nil, fmt.Errorf("unknown action '%s' for exit code %d (use success, skip or fail)", action, code)
+		}
+	}
+	return actions, nil
+}
+
+// Write the -metrics-file, if configured; a failed write is reported but doesn't fail the run
+func writeMetrics(config Config, stats *ProcessingStats, elapsed time.Duration, logger *log.Logger) {
+	if config.MetricsFile == "" {
+		return
+	}
+	if err := writeMetricsFile(config.MetricsFile, stats, elapsed); err != nil {
+		logAndPrint(logger, fmt.Sprintf("WARNING: Failed to write metrics file %s: %v", config.MetricsFile, err), config.Verbose)
+		return
+	}
+	logAndPrint(logger, fmt.Sprintf("INFO: Wrote metrics to %s", config.MetricsFile), config.Verbose)
+}
+
+// Write the -failed-out list, if configured. The file is always rewritten, so a run without
+// failures leaves it empty rather than holding the previous run's failures.
+func writeFailedList(config Config, stats *ProcessingStats, logger *log.Logger) {
+	if config.FailedOut == "" {
+		return
+	}
+	stats.mu.Lock()
+	var b strings.Builder
+	for _, filePath := range stats.FailedFiles {
+		b.WriteString(filePath + "\n")
+	}
+	count := len(stats.FailedFiles)
+	stats.mu.Unlock()
+
+	if err := writeFileAtomic(config.FailedOut, []byte(b.String())); err != nil {
+		logAndPrint(logger, fmt.Sprintf("WARNING: Failed to write failed file list %s: %v", config.FailedOut, err), config.Verbose)
+		return
+	}
+	logAndPrint(logger, fmt.Sprintf("INFO: Wrote %d failed file paths to %s", count, config.FailedOut), config.Verbose)
+}
+
+// Print the -summary-only report to stdout: a single line when every file succeeded, or
+// the summary followed by the run's warnings and each failed file's error otherwise
+func printSummaryReport(stats *ProcessingStats, elapsed time.Duration, warnings []string) {
+	if !stats.hasFailures() && len(warnings) == 0 {
+		fmt.Printf("OK: %s in %s\n", stats.getSummary(), formatDuration(elapsed))
+		return
+	}
+
+	fmt.Printf("FAILED: %s in %s\n", stats.getSummary(), formatDuration(elapsed))
+	for _, warning := range warnings {
+		fmt.Println(warning)
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	for _, filePath := range stats.FailedFiles {
+		fmt.Printf("\n%s:\n%s\n", filePath, stats.FailReasons[filePath])
+	}
 }
 
 // ParseFabricCommand parses a fabric command string into command name and arguments
@@ -166,25 +846,33 @@ func parseFabricCommand(cmdString string) (string, []string) {
 	return parts[0], parts[1:]
 }
 
-// Find all input files (JSON and markdown)
-func findInputFiles(inputFolder string) ([]string, error) {
-	var allFiles []string
-
-	// Find JSON files
-	jsonFiles, err := filepath.Glob(filepath.Join(inputFolder, "*.json"))
+// Dispatch the manifest's files that are new or changed since the prior manifest. Entry
+// paths are relative to the input folder, or are entry names in a zip archive input.
+func findManifestFiles(config Config, found func(filePath string), logger *log.Logger) error {
+	current, err := manifest.Load(config.Manifest)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	allFiles = append(allFiles, jsonFiles...)
-
-	// Find markdown files
-	mdFiles, err := filepath.Glob(filepath.Join(inputFolder, "*.md"))
-	if err != nil {
-		return nil, err
+	var prior *manifest.Manifest
+	if config.PriorManifest != "" {
+		prior, err = manifest.Load(config.PriorManifest)
+		if err != nil {
+			return err
+		}
 	}
-	allFiles = append(allFiles, mdFiles...)
 
-	return allFiles, nil
+	changed := manifest.Changed(prior, current)
+	message := fmt.Sprintf("INFO: Manifest lists %d files, %d new or changed", len(current.Entries), len(changed))
+	logAndPrint(logger, message, config.Verbose)
+
+	for _, entry := range changed {
+		if isZipInput(config.InputFolder) {
+			found(entry.File) // Archive entry names always use forward slashes
+		} else {
+			found(filepath.Join(config.InputFolder, filepath.FromSlash(entry.File)))
+		}
+	}
+	return nil
 }
 
 // Detect the file type based on file extension
@@ -200,6 +888,88 @@ func detectFileType(filePath string) string {
 	}
 }
 
+// Get the output folder for a file type, honoring the per-type overrides
+func outputFolderFor(config Config, fileType string) string {
+	switch {
+	case fileType == FileTypeJSON && config.OutputJSON != "":
+		return config.OutputJSON
+	case fileType == FileTypeMarkdown && config.OutputMD != "":
+		return config.OutputMD
+	default:
+		return config.OutputFolder
+	}
+}
+
+// Get the output path for an input file: <base>.md in its output folder, or the rendered
+// -output-template relative to that folder
+func outputPathFor(config Config, filePath string) (string, error) {
+	fileName := filepath.Base(filePath)
+	fileNameWithoutExt := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	fileType := detectFileType(filePath)
+	folder := outputFolderFor(config, fileType)
+	if config.OutputTemplate == nil || fileType == FileTypeUnknown {
+		return filepath.Join(folder, fileNameWithoutExt+".md"), nil
+	}
+
+	relative, err := renderOutputPath(config.OutputTemplate, outputTemplateData{
+		Base: fileNameWithoutExt,
+		Type: fileType,
+		Date: templateDate{config.RunStarted},
+		Ext:  filepath.Ext(fileName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(folder, relative), nil
+}
+
+// Build the text piped to fabric: the file content framed by the optional prefix and suffix,
+// each separated from the content by a newline
+func buildFabricInput(config Config, content []byte) []byte {
+	if config.InputPrefix == "" && config.InputSuffix == "" {
+		return content
+	}
+
+	var input []byte
+	if config.InputPrefix != "" {
+		input = append(input, config.InputPrefix...)
+		if !strings.HasSuffix(config.InputPrefix, "\n") {
+			input = append(input, '\n')
+		}
+	}
+	input = append(input, content...)
+	if config.InputSuffix != "" {
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			input = append(input, '\n')
+		}
+		input = append(input, config.InputSuffix...)
+	}
+	return input
+}
+
+// Write a file via a temporary file and rename, so a partially written output is never
+// left at path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // Ensure a directory exists, creating it if necessary
 func ensureDirectoryExists(dir string) {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -207,7 +977,7 @@ func ensureDirectoryExists(dir string) {
 			fmt.Printf("Failed to create directory: %s - %v\n", dir, err)
 			os.Exit(1)
 		}
-		fmt.Printf("Created directory: %s\n", dir)
+		fmt.Fprintf(console, "Created directory: %s\n", dir)
 	}
 }
 
@@ -228,117 +998,399 @@ func initLogFile(logFilePath string) *os.File {
 		os.Exit(1)
 	}
 
-	fmt.Printf("Initialized log file: %s\n", logFilePath)
+	fmt.Fprintf(console, "Initialized log file: %s\n", logFilePath)
 	return logFile
 }
 
 // Process a single file (JSON or markdown)
-func processFile(filePath string, config Config, logger *log.Logger, mutex *sync.Mutex, stats *ProcessingStats) {
+func processFile(ctx context.Context, filePath string, readInput func(string) ([]byte, error), config Config, logger *log.Logger, mutex *sync.Mutex, stats *ProcessingStats) {
 	fileName := filepath.Base(filePath)
 	fileNameWithoutExt := strings.TrimSuffix(fileName, filepath.Ext(fileName))
-	outputFilePath := filepath.Join(config.OutputFolder, fileNameWithoutExt+".md")
 	fileType := detectFileType(filePath)
 
-	// Parse the fabric command into base command and arguments
-	cmdName, cmdArgs := parseFabricCommand(config.FabricCommand)
-
-	if cmdName == "" {
-		message := "ERROR: Empty fabric command specified"
-		logMessage(logger, message, mutex)
-		fmt.Println(message)
-		stats.incrementFailed(mutex)
-		return
+	// Name the output, creating the folders of a templated path
+	outputFilePath, err := outputPathFor(config, filePath)
+	if config.OutputTemplate != nil && fileType != FileTypeUnknown {
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(outputFilePath), 0755)
+		}
+		if err != nil {
+			message := fmt.Sprintf("ERROR: Failed to name output for %s from -output-template: %v", filePath, err)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementFailed(filePath, message)
+			return
+		}
 	}
 
 	// Log file processing information
 	if config.Verbose {
-		fmt.Printf("Processing file: %s (type: %s)\n", filePath, fileType)
-		fmt.Printf("Input file: %s\n", filePath)
-		fmt.Printf("Output file: %s\n", outputFilePath)
-		fmt.Printf("Using fabric command: %s with args: %v\n", cmdName, cmdArgs)
+		fmt.Fprintf(console, "Processing file: %s (type: %s)\n", filePath, fileType)
+		fmt.Fprintf(console, "Input file: %s\n", filePath)
+		fmt.Fprintf(console, "Output file: %s\n", outputFilePath)
 	}
 
 	// Skip unknown file types
 	if fileType == FileTypeUnknown {
 		message := fmt.Sprintf("WARNING: Skipping file with unknown type: %s", filePath)
 		logMessage(logger, message, mutex)
-		fmt.Println(message)
-		stats.incrementSkipped(mutex)
+		fmt.Fprintln(console, message)
+		stats.incrementSkipped()
 		return
 	}
 
+	// Apply the overwrite policy when the output already exists
+	if config.OnExists != OnExistsOverwrite {
+		if _, err := os.Stat(outputFilePath); err == nil {
+			switch config.OnExists {
+			case OnExistsSkip:
+				message := fmt.Sprintf("INFO: Skipping file %s - output %s already exists", filePath, outputFilePath)
+				logMessage(logger, message, mutex)
+				if config.Verbose {
+					fmt.Fprintln(console, message)
+				}
+				stats.incrementSkipped()
+				return
+			case OnExistsFail:
+				message := fmt.Sprintf("ERROR: Output %s for %s already exists", outputFilePath, filePath)
+				logMessage(logger, message, mutex)
+				fmt.Fprintln(console, message)
+				stats.incrementFailed(filePath, message)
+				return
+			case OnExistsVersion:
+				outputFilePath = outputVersions.next(outputFilePath)
+				if config.Verbose {
+					fmt.Fprintf(console, "Output exists, writing new version: %s\n", outputFilePath)
+				}
+			}
+		}
+	}
+
 	// Read the content of the input file
-	content, err := os.ReadFile(filePath)
+	content, err := readInput(filePath)
 	if err != nil {
 		message := fmt.Sprintf("ERROR: Failed to read file %s - %v", filePath, err)
 		logMessage(logger, message, mutex)
-		fmt.Println(message)
-		stats.incrementFailed(mutex)
+		fmt.Fprintln(console, message)
+		stats.incrementFailed(filePath, message)
 		return
 	}
 
-	// Create the fabric command with appropriate arguments
-	fabArgs := append([]string{"-p", cmdName}, cmdArgs...)
-	fabArgs = append(fabArgs, "-o", outputFilePath)
+	// Don't spend a fabric call on stray markdown, such as a README, that isn't a profile
+	if reason := markerReason(config, fileType, content); reason != "" {
+		message := fmt.Sprintf("WARNING: Skipping file %s - %s", filePath, reason)
+		logMessage(logger, message, mutex)
+		fmt.Fprintln(console, message)
+		stats.incrementSkipped()
+		return
+	}
 
-	cmd := exec.Command("fabric", fabArgs...)
+	// Choose the fabric command for this file and parse it into base command and arguments
+	fabricCommand := config.FabricCommand
+	if config.CommandMap != nil {
+		fabricCommand = config.CommandMap.commandFor(fileName, fileType, content, config.FabricCommand)
+	}
+	cmdName, cmdArgs := parseFabricCommand(fabricCommand)
 
+	if cmdName == "" {
+		message := "ERROR: Empty fabric command specified"
+		logMessage(logger, message, mutex)
+		fmt.Fprintln(console, message)
+		stats.incrementFailed(filePath, message)
+		return
+	}
 	if config.Verbose {
-		fmt.Printf("Executing command: fabric %s\n", strings.Join(fabArgs, " "))
+		fmt.Fprintf(console, "Using fabric command: %s with args: %v\n", cmdName, cmdArgs)
 	}
 
-	// Create stdin pipe
-	stdin, err := cmd.StdinPipe()
+	// Give fabric a clean markdown rendering of JSON profiles, keeping the original for
+	// -embed-source
+	source := content
+	if config.Prerender && fileType == FileTypeJSON {
+		var rendered bool
+		content, rendered = prerenderJSON(content)
+		if config.Verbose && !rendered {
+			fmt.Fprintf(console, "Not a recognized profile shape, passing JSON through: %s\n", filePath)
+		}
+	}
+
+	// Oversized content is run through fabric in chunks whose outputs are merged here, so
+	// fabric's -o can't be used for it
+	chunks, err := splitChunks(content, config.ChunkBytes)
 	if err != nil {
-		message := fmt.Sprintf("ERROR: Failed to create stdin pipe for fabric command - %v", err)
+		message := fmt.Sprintf("ERROR: Failed to split %s into chunks - %v", filePath, err)
 		logMessage(logger, message, mutex)
-		fmt.Println(message)
-		stats.incrementFailed(mutex)
+		fmt.Fprintln(console, message)
+		stats.incrementFailed(filePath, message)
 		return
 	}
+	chunked := len(chunks) > 1
+	if chunked && config.Verbose {
+		fmt.Fprintf(console, "Splitting %s (%d bytes) into %d chunks\n", filePath, len(content), len(chunks))
+	}
 
-	// Redirect stdout and stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Batched files share a fabric run whose output is split here, so -o can't be used for
+	// them either
+	batched := config.Batcher != nil && !chunked
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		message := fmt.Sprintf("ERROR: Failed to start fabric command '%s' for %s - %v", config.FabricCommand, filePath, err)
-		logMessage(logger, message, mutex)
-		fmt.Println(message)
-		stats.incrementFailed(mutex)
-		return
+	// Create the fabric command with appropriate arguments
+	fabArgs := append([]string{"-p", cmdName}, cmdArgs...)
+	if !config.CaptureStdout && !chunked && !batched {
+		fabArgs = append(fabArgs, "-o", outputFilePath)
 	}
 
-	// Write content to stdin and close it
-	if _, err := stdin.Write(content); err != nil {
-		message := fmt.Sprintf("ERROR: Failed to write to fabric stdin for %s - %v", filePath, err)
-		logMessage(logger, message, mutex)
-		fmt.Println(message)
-		stats.incrementFailed(mutex)
-		return
+	if config.Verbose {
+		fmt.Fprintf(console, "Executing command: fabric %s\n", strings.Join(fabArgs, " "))
+	}
+
+	// Redirect stdout and stderr. With -summary-only, fabric's stderr is kept for the
+	// failure report instead of being shown.
+	var captured, fabricStderr bytes.Buffer
+	var stdout, stderr io.Writer = console, os.Stderr
+	if config.CaptureStdout || chunked || batched {
+		stdout = &captured
 	}
-	stdin.Close()
+	if config.SummaryOnly {
+		stderr = &fabricStderr
+	}
+
+	startTime := time.Now()
+	var runErr error
+	if batched {
+		var output []byte
+		output, runErr = config.Batcher.run(ctx, fabArgs, content, stderr)
+		captured.Write(output)
+	} else if chunked {
+		runErr = runFabricChunks(ctx, config, fabArgs, chunks, stdout, stderr)
+	} else {
+		cmd := exec.CommandContext(ctx, "fabric", fabArgs...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
 
-	// Wait for the command to finish
-	if err := cmd.Wait(); err != nil {
-		message := fmt.Sprintf("ERROR: Failed to process file '%s' with command '%s'. Error: %v", filePath, config.FabricCommand, err)
+		// Create stdin pipe
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			message := fmt.Sprintf("ERROR: Failed to create stdin pipe for fabric command - %v", err)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementFailed(filePath, message)
+			return
+		}
+
+		// Start the command
+		if err := cmd.Start(); err != nil {
+			message := fmt.Sprintf("ERROR: Failed to start fabric command '%s' for %s - %v", fabricCommand, filePath, err)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementFailed(filePath, message)
+			return
+		}
+
+		// Write content (with any framing text) to stdin and close it
+		if _, err := stdin.Write(buildFabricInput(config, content)); err != nil {
+			message := fmt.Sprintf("ERROR: Failed to write to fabric stdin for %s - %v", filePath, err)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementFailed(filePath, message)
+			return
+		}
+		stdin.Close()
+
+		// Wait for the command to finish
+		runErr = cmd.Wait()
+	}
+
+	// A cancelled run is a skip, not another failure
+	if err := runErr; err != nil {
+		if ctx.Err() != nil {
+			message := fmt.Sprintf("WARNING: Cancelled processing of file %s", filePath)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementSkipped()
+			return
+		}
+
+		// Known non-fatal exit codes can be reclassified with -exit-codes
+		action := ExitActionFail
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if mapped, ok := config.ExitActions[exitErr.ExitCode()]; ok {
+				action = mapped
+			}
+		}
+		switch action {
+		case ExitActionSkip:
+			message := fmt.Sprintf("WARNING: Skipping file %s - fabric exited with code %d", filePath, exitErr.ExitCode())
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementSkipped()
+			return
+		case ExitActionSuccess:
+			message := fmt.Sprintf("WARNING: Fabric exited with code %d for %s; treating as success", exitErr.ExitCode(), filePath)
+			logMessage(logger, message, mutex)
+			if config.Verbose {
+				fmt.Fprintln(console, message)
+			}
+		default:
+			message := fmt.Sprintf("ERROR: Failed to process file '%s' with command '%s'. Error: %v", filePath, fabricCommand, err)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			reason := message
+			if details := strings.TrimSpace(fabricStderr.String()); details != "" {
+				reason += "\n" + details
+			}
+			stats.incrementFailed(filePath, reason)
+			return
+		}
+	}
+	elapsed := time.Since(startTime)
+
+	// Write the captured, merged or batched output in place of fabric's -o
+	if config.CaptureStdout || chunked || batched {
+		if err := writeFileAtomic(outputFilePath, captured.Bytes()); err != nil {
+			message := fmt.Sprintf("ERROR: Failed to write output file %s for %s - %v", outputFilePath, filePath, err)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementFailed(filePath, message)
+			return
+		}
+	}
+
+	// Unwrap an output fabric fenced as a whole
+	if config.StripFences {
+		if output, err := os.ReadFile(outputFilePath); err == nil {
+			if stripped, ok := stripFences(output); ok {
+				if err := writeFileAtomic(outputFilePath, stripped); err != nil {
+					message := fmt.Sprintf("ERROR: Failed to strip code fence from output %s for %s - %v", outputFilePath, filePath, err)
+					logMessage(logger, message, mutex)
+					fmt.Fprintln(console, message)
+					stats.incrementFailed(filePath, message)
+					return
+				}
+				if config.Verbose {
+					fmt.Fprintf(console, "Stripped code fence from output: %s\n", outputFilePath)
+				}
+			}
+		}
+	}
+
+	// A zero exit doesn't guarantee fabric wrote anything
+	if info, err := os.Stat(outputFilePath); err != nil || info.Size() == 0 {
+		if config.EmptyIsFailed {
+			message := fmt.Sprintf("ERROR: Fabric produced no output for '%s' at %s", filePath, outputFilePath)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementFailed(filePath, message)
+			return
+		}
+		message := fmt.Sprintf("WARNING: Fabric produced no output for '%s' at %s", filePath, outputFilePath)
 		logMessage(logger, message, mutex)
-		fmt.Println(message)
-		stats.incrementFailed(mutex)
-		return
+		fmt.Fprintln(console, message)
+	}
+
+	// Catch summaries that were written but are degraded
+	if validatesOutput(config) {
+		output, err := os.ReadFile(outputFilePath)
+		if err == nil {
+			err = validateOutput(config, output)
+		}
+		if err != nil {
+			message := fmt.Sprintf("ERROR: Output %s for '%s' failed validation - %v", outputFilePath, filePath, err)
+			if config.DeleteInvalid {
+				if removeErr := os.Remove(outputFilePath); removeErr == nil {
+					message += " (output deleted)"
+				}
+			}
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementFailed(filePath, message)
+			return
+		}
 	}
 
-	message := fmt.Sprintf("SUCCESS: Processed file '%s' (type: %s) successfully with command '%s'.", filePath, fileType, config.FabricCommand)
+	// Append the input to the validated summary so the output carries the raw data too
+	if embedsSource(config, fileType) {
+		output, err := os.ReadFile(outputFilePath)
+		if err == nil {
+			err = writeFileAtomic(outputFilePath, embedSource(config, output, fileType, source))
+		}
+		if err != nil {
+			message := fmt.Sprintf("ERROR: Failed to embed source in output %s for %s - %v", outputFilePath, filePath, err)
+			logMessage(logger, message, mutex)
+			fmt.Fprintln(console, message)
+			stats.incrementFailed(filePath, message)
+			return
+		}
+	}
+
+	message := fmt.Sprintf("SUCCESS: Processed file '%s' (type: %s) successfully with command '%s'.", filePath, fileType, fabricCommand)
+	if config.Verbose {
+		message = fmt.Sprintf("SUCCESS: Processed file '%s' (type: %s) successfully with command '%s' in %s.", filePath, fileType, fabricCommand, formatDuration(elapsed))
+		stats.recordDuration(elapsed)
+	}
 	logMessage(logger, message, mutex)
 	if config.Verbose {
-		fmt.Println(message)
+		fmt.Fprintln(console, message)
 	} else {
-		fmt.Printf("Processed: %s (%s)\n", fileNameWithoutExt, fileType)
+		fmt.Fprintf(console, "Processed: %s (%s)\n", fileNameWithoutExt, fileType)
 	}
 
 	// Update statistics
-	stats.incrementSuccessful(mutex, fileType)
+	stats.incrementSuccessful(fileType, fabricCommand)
+}
+
+// Process a single document read from in, writing fabric's output to out
+func processStdin(config Config, in io.Reader, out io.Writer) error {
+	if config.StdinType != FileTypeJSON && config.StdinType != FileTypeMarkdown {
+		return fmt.Errorf("unsupported -stdin-type '%s' (use %s or %s)", config.StdinType, FileTypeJSON, FileTypeMarkdown)
+	}
+
+	content, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("failed to read stdin - %w", err)
+	}
+
+	// Only field rules of a -cmd-map apply, since stdin has no filename
+	fabricCommand := config.FabricCommand
+	if config.CommandMap != nil {
+		fabricCommand = config.CommandMap.commandFor("", config.StdinType, content, config.FabricCommand)
+	}
+	cmdName, cmdArgs := parseFabricCommand(fabricCommand)
+	if cmdName == "" {
+		return fmt.Errorf("empty fabric command specified")
+	}
+	source := content
+	if config.Prerender && config.StdinType == FileTypeJSON {
+		content, _ = prerenderJSON(content)
+	}
+
+	fabArgs := append([]string{"-p", cmdName}, cmdArgs...)
+	cmd := exec.Command("fabric", fabArgs...)
+	cmd.Stdin = bytes.NewReader(buildFabricInput(config, content))
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+
+	if config.Verbose {
+		fmt.Fprintf(os.Stderr, "Executing command: fabric %s (stdin type: %s)\n", strings.Join(fabArgs, " "), config.StdinType)
+	}
+
+	chunks, err := splitChunks(content, config.ChunkBytes)
+	if err != nil {
+		return fmt.Errorf("failed to split stdin into chunks - %w", err)
+	}
+	if len(chunks) > 1 {
+		if err := runFabricChunks(context.Background(), config, fabArgs, chunks, out, os.Stderr); err != nil {
+			return fmt.Errorf("failed to process stdin with command '%s' - %w", fabricCommand, err)
+		}
+	} else if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to process stdin with command '%s' - %w", fabricCommand, err)
+	}
+
+	// fabric's output has already streamed to out, so the source follows it
+	if embedsSource(config, config.StdinType) {
+		fmt.Fprintf(out, "\n%s", sourceBlock(config, config.StdinType, source))
+	}
+	return nil
 }
 
 // Log a message to the log file
@@ -355,11 +1407,11 @@ func logAndPrint(logger *log.Logger, message string, verbose bool) {
 	timestamp := time.Now().Format(time.RFC3339)
 	logger.Println(timestamp + " - " + message)
 	if verbose {
-		fmt.Println(message)
+		fmt.Fprintln(console, message)
 	} else {
 		// Print important messages even in non-verbose mode
 		if strings.HasPrefix(message, "INFO:") || strings.HasPrefix(message, "WARNING:") {
-			fmt.Println(message)
+			fmt.Fprintln(console, message)
 		}
 	}
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Render the run's statistics in the Prometheus text exposition format
+func formatMetrics(stats *ProcessingStats, elapsed time.Duration) string {
+	var b strings.Builder
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, kind)
+		fmt.Fprintf(&b, "%s %s\n", name, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+
+	metric("linkedin_profiles_processed_total", "counter", "Files summarized successfully by fabric.", float64(stats.Successful.Load()))
+	metric("linkedin_profiles_failed_total", "counter", "Files that failed to process.", float64(stats.Failed.Load()))
+	metric("linkedin_profiles_skipped_total", "counter", "Files skipped without a summary.", float64(stats.Skipped.Load()))
+	metric("linkedin_profiles_duration_seconds", "gauge", "Wall-clock duration of the run.", elapsed.Seconds())
+	metric("linkedin_profiles_last_run_timestamp_seconds", "gauge", "Unix time the run finished.", float64(time.Now().Unix()))
+	return b.String()
+}
+
+// Write metrics for the node_exporter textfile collector. The file is replaced atomically
+// so the collector never reads a partial write.
+func writeMetricsFile(path string, stats *ProcessingStats, elapsed time.Duration) error {
+	return writeFileAtomic(path, []byte(formatMetrics(stats, elapsed)))
+}
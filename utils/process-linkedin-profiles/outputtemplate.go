@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateDate prints as YYYY-MM-DD in an -output-template, and its Format method allows
+// other layouts such as {{.Date.Format "2006-01"}}
+type templateDate struct {
+	time.Time
+}
+
+func (d templateDate) String() string {
+	return d.Format("2006-01-02")
+}
+
+// outputTemplateData holds the variables available to an -output-template
+type outputTemplateData struct {
+	Base string       // Input filename without its extension
+	Type string       // Input file type: json or md
+	Date templateDate // Date the run started
+	Ext  string       // Input file extension including the dot, e.g. .json
+}
+
+// Parse an -output-template and check it renders with sample values
+func parseOutputTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := outputTemplateData{Base: "profile", Type: FileTypeJSON, Date: templateDate{time.Now()}, Ext: ".json"}
+	if _, err := renderOutputPath(tmpl, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Render an output path relative to the output folder. The path must stay inside the folder.
+func renderOutputPath(tmpl *template.Template, data outputTemplateData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	path := filepath.Clean(filepath.FromSlash(b.String()))
+	if b.Len() == 0 || path == "." {
+		return "", fmt.Errorf("template rendered an empty path")
+	}
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("rendered path %s is outside the output folder", path)
+	}
+	return path, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// List what a run would do with each discovered file, without running fabric or writing
+// anything, for -plan. Files are classified in the order processFile checks them: -include
+// and -exclude, the output name, the file type, the -on-exists policy, then -md-marker.
+func planFiles(config Config, files []string, readInput func(string) ([]byte, error), out io.Writer) {
+	processCount, skipCount, failCount := 0, 0, 0
+	for _, filePath := range files {
+		if reason := filterReason(config, filePath); reason != "" {
+			fmt.Fprintf(out, "skip     %s (filtered: %s)\n", filePath, reason)
+			skipCount++
+			continue
+		}
+		fileType := detectFileType(filePath)
+		if fileType == FileTypeUnknown {
+			fmt.Fprintf(out, "skip     %s (unknown file type)\n", filePath)
+			skipCount++
+			continue
+		}
+		outputFilePath, err := outputPathFor(config, filePath)
+		if err != nil {
+			fmt.Fprintf(out, "fail     %s (naming output from -output-template: %v)\n", filePath, err)
+			failCount++
+			continue
+		}
+
+		if _, err := os.Stat(outputFilePath); err == nil {
+			switch config.OnExists {
+			case OnExistsSkip:
+				fmt.Fprintf(out, "skip     %s (output %s exists)\n", filePath, outputFilePath)
+				skipCount++
+				continue
+			case OnExistsFail:
+				fmt.Fprintf(out, "fail     %s (output %s exists)\n", filePath, outputFilePath)
+				failCount++
+				continue
+			case OnExistsVersion:
+				outputFilePath = outputVersions.next(outputFilePath)
+			}
+		}
+
+		// Check the markers and name the command and chunking the file would get, which
+		// depend on its content
+		var details []string
+		if config.CommandMap != nil || config.ChunkBytes > 0 || (len(config.MarkdownMarkers) > 0 && fileType == FileTypeMarkdown) {
+			content, err := readInput(filePath)
+			if err != nil {
+				fmt.Fprintf(out, "fail     %s (reading input: %v)\n", filePath, err)
+				failCount++
+				continue
+			}
+			if reason := markerReason(config, fileType, content); reason != "" {
+				fmt.Fprintf(out, "skip     %s (%s)\n", filePath, reason)
+				skipCount++
+				continue
+			}
+			if config.CommandMap != nil {
+				details = append(details, "command: "+config.CommandMap.commandFor(filepath.Base(filePath), fileType, content, config.FabricCommand))
+			}
+			if config.Prerender && fileType == FileTypeJSON {
+				content, _ = prerenderJSON(content)
+			}
+			chunks, err := splitChunks(content, config.ChunkBytes)
+			if err != nil {
+				fmt.Fprintf(out, "fail     %s (chunking: %v)\n", filePath, err)
+				failCount++
+				continue
+			}
+			if len(chunks) > 1 {
+				details = append(details, fmt.Sprintf("%d chunks", len(chunks)))
+			}
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(out, "process  %s -> %s (%s)\n", filePath, outputFilePath, strings.Join(details, ", "))
+		} else {
+			fmt.Fprintf(out, "process  %s -> %s\n", filePath, outputFilePath)
+		}
+		processCount++
+	}
+	fmt.Fprintf(out, "Plan: %d files, %d to process, %d skipped, %d would fail\n", len(files), processCount, skipCount, failCount)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Pre-render a JSON profile as markdown for -prerender. Content that isn't a JSON object
+// with recognizable profile fields is returned unchanged, along with false.
+func prerenderJSON(content []byte) ([]byte, bool) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return content, false
+	}
+	rendered := renderProfileMarkdown(data)
+	if rendered == "" {
+		return content, false
+	}
+	return []byte(rendered), true
+}
+
+// renderProfileMarkdown renders the common LinkedIn profile fields (name, headline, location,
+// about, experience, education and skills) as markdown. It returns "" when the record has
+// none of the name, headline or experience fields, so callers can pass it through as is.
+func renderProfileMarkdown(data map[string]interface{}) string {
+	name := firstString(data, "fullName", "name")
+	if name == "" {
+		name = strings.TrimSpace(firstString(data, "firstName") + " " + firstString(data, "lastName"))
+	}
+	headline := firstString(data, "headline", "occupation")
+	experience := firstList(data, "experience", "experiences", "positions")
+	if name == "" && headline == "" && len(experience) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	if name != "" {
+		fmt.Fprintf(&b, "# %s\n\n", name)
+	}
+	if headline != "" {
+		fmt.Fprintf(&b, "%s\n\n", headline)
+	}
+	if location := firstString(data, "location", "locationName", "geoLocationName", "addressWithCountry"); location != "" {
+		fmt.Fprintf(&b, "Location: %s\n\n", location)
+	}
+	if about := firstString(data, "summary", "about"); about != "" {
+		fmt.Fprintf(&b, "## About\n\n%s\n\n", about)
+	}
+
+	if len(experience) > 0 {
+		b.WriteString("## Experience\n\n")
+		for _, item := range experience {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			line := firstString(entry, "title", "position")
+			if company := firstString(entry, "companyName", "company", "subtitle"); company != "" {
+				if line != "" {
+					line += " at "
+				}
+				line += company
+			}
+			if dates := formatDateRange(entry); dates != "" {
+				line += " (" + dates + ")"
+			}
+			if line == "" {
+				continue
+			}
+			fmt.Fprintf(&b, "- %s\n", line)
+			if description := firstString(entry, "description"); description != "" {
+				fmt.Fprintf(&b, "  %s\n", strings.ReplaceAll(description, "\n", "\n  "))
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	if education := firstList(data, "education", "educations"); len(education) > 0 {
+		b.WriteString("## Education\n\n")
+		for _, item := range education {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var parts []string
+			for _, field := range []string{"degreeName", "degree", "fieldOfStudy"} {
+				if value := firstString(entry, field); value != "" {
+					parts = append(parts, value)
+				}
+			}
+			line := strings.Join(parts, ", ")
+			if school := firstString(entry, "schoolName", "school", "title"); school != "" {
+				if line != "" {
+					line += " - "
+				}
+				line += school
+			}
+			if dates := formatDateRange(entry); dates != "" {
+				line += " (" + dates + ")"
+			}
+			if line != "" {
+				fmt.Fprintf(&b, "- %s\n", line)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	// Skills are either plain strings or objects with a name
+	var skills []string
+	for _, item := range firstList(data, "skills") {
+		switch skill := item.(type) {
+		case string:
+			skills = append(skills, skill)
+		case map[string]interface{}:
+			if value := firstString(skill, "name", "title"); value != "" {
+				skills = append(skills, value)
+			}
+		}
+	}
+	if len(skills) > 0 {
+		fmt.Fprintf(&b, "## Skills\n\n%s\n", strings.Join(skills, ", "))
+	}
+
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// Return the first of the given fields holding a non-empty string, trimmed
+func firstString(data map[string]interface{}, fields ...string) string {
+	for _, field := range fields {
+		if value, ok := data[field].(string); ok && strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Return the first of the given fields holding a non-empty array
+func firstList(data map[string]interface{}, fields ...string) []interface{} {
+	for _, field := range fields {
+		if value, ok := data[field].([]interface{}); ok && len(value) > 0 {
+			return value
+		}
+	}
+	return nil
+}
+
+// Format an entry's dates from a dateRange string, or from startDate and endDate given as
+// strings or {"month", "year"} objects. A start without an end is still ongoing.
+func formatDateRange(entry map[string]interface{}) string {
+	if dates := firstString(entry, "dateRange", "duration", "period"); dates != "" {
+		return dates
+	}
+	start := formatDate(entry["startDate"])
+	end := formatDate(entry["endDate"])
+	switch {
+	case start != "" && end != "":
+		return start + " - " + end
+	case start != "":
+		return start + " - present"
+	default:
+		return end
+	}
+}
+
+// Format a date given as a string or a {"month", "year"} object
+func formatDate(value interface{}) string {
+	switch date := value.(type) {
+	case string:
+		return strings.TrimSpace(date)
+	case map[string]interface{}:
+		year, hasYear := date["year"].(float64)
+		if !hasYear {
+			return ""
+		}
+		if month, ok := date["month"].(float64); ok && month >= 1 && month <= 12 {
+			return fmt.Sprintf("%04d-%02d", int(year), int(month))
+		}
+		return fmt.Sprintf("%d", int(year))
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Start the pool with one free worker slot and release the others evenly over ramp, for
+// -ramp. All but one token are taken from the semaphore up front and handed back one at a
+// time, so at most one more fabric run starts per interval until every worker is in use.
+func rampWorkers(ctx context.Context, semaphore chan struct{}, workers int, ramp time.Duration) {
+	if ramp <= 0 || workers <= 1 {
+		return
+	}
+	held := workers - 1
+	for i := 0; i < held; i++ {
+		semaphore <- struct{}{}
+	}
+
+	interval := ramp / time.Duration(held)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for i := 0; i < held; i++ {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			<-semaphore
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Run fabric on the first files one at a time and print each output for review. Outputs are
+// only written to their usual paths when keep is set. It returns the number of files that
+// failed.
+func sampleFiles(config Config, files []string, readInput func(string) ([]byte, error), keep bool, out io.Writer) int {
+	failed := 0
+	for i, filePath := range files {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		output, fabricCommand, err := sampleFile(config, filePath, readInput)
+		if err != nil {
+			fmt.Fprintf(out, "ERROR: Failed to sample file %s - %v\n", filePath, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "==> %s (command: %s) <==\n", filePath, fabricCommand)
+		out.Write(output)
+		if len(output) > 0 && output[len(output)-1] != '\n' {
+			fmt.Fprintln(out)
+		}
+
+		if keep {
+			outputFilePath, err := outputPathFor(config, filePath)
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(outputFilePath), 0755)
+			}
+			if err == nil {
+				err = writeFileAtomic(outputFilePath, output)
+			}
+			if err != nil {
+				fmt.Fprintf(out, "ERROR: Failed to keep sample output for %s - %v\n", filePath, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(out, "Kept sample output at %s\n", outputFilePath)
+		}
+	}
+	return failed
+}
+
+// Run fabric on one file as processFile would, returning its stdout and the command used
+func sampleFile(config Config, filePath string, readInput func(string) ([]byte, error)) ([]byte, string, error) {
+	fileType := detectFileType(filePath)
+	content, err := readInput(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	fabricCommand := config.FabricCommand
+	if config.CommandMap != nil {
+		fabricCommand = config.CommandMap.commandFor(filepath.Base(filePath), fileType, content, config.FabricCommand)
+	}
+	cmdName, cmdArgs := parseFabricCommand(fabricCommand)
+	if cmdName == "" {
+		return nil, fabricCommand, fmt.Errorf("empty fabric command specified")
+	}
+	source := content
+	if config.Prerender && fileType == FileTypeJSON {
+		content, _ = prerenderJSON(content)
+	}
+
+	fabArgs := append([]string{"-p", cmdName}, cmdArgs...)
+	if config.Verbose {
+		fmt.Printf("Executing command: fabric %s\n", strings.Join(fabArgs, " "))
+	}
+	var output bytes.Buffer
+	chunks, err := splitChunks(content, config.ChunkBytes)
+	if err != nil {
+		return nil, fabricCommand, fmt.Errorf("splitting into chunks - %w", err)
+	}
+	if len(chunks) > 1 {
+		if err := runFabricChunks(context.Background(), config, fabArgs, chunks, &output, os.Stderr); err != nil {
+			return nil, fabricCommand, fmt.Errorf("command '%s' - %w", fabricCommand, err)
+		}
+		return sampleOutput(config, output.Bytes(), fileType, source), fabricCommand, nil
+	}
+	cmd := exec.Command("fabric", fabArgs...)
+	cmd.Stdin = bytes.NewReader(buildFabricInput(config, content))
+	cmd.Stdout = &output
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fabricCommand, fmt.Errorf("command '%s' - %w", fabricCommand, err)
+	}
+	return sampleOutput(config, output.Bytes(), fileType, source), fabricCommand, nil
+}
+
+// Post-process a sample's output as processFile does for output files
+func sampleOutput(config Config, output []byte, fileType string, source []byte) []byte {
+	if config.StripFences {
+		output, _ = stripFences(output)
+	}
+	if embedsSource(config, fileType) {
+		output = embedSource(config, output, fileType, source)
+	}
+	return output
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// stringList collects the values of a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Report whether any output validation is configured
+func validatesOutput(config Config) bool {
+	return config.MinOutputBytes > 0 || len(config.RequireContains) > 0
+}
+
+// Check a summary against -min-output-bytes and -require-contains, describing every check
+// it fails
+func validateOutput(config Config, content []byte) error {
+	var problems []string
+	if config.MinOutputBytes > 0 && int64(len(content)) < config.MinOutputBytes {
+		problems = append(problems, fmt.Sprintf("%d bytes, minimum %d", len(content), config.MinOutputBytes))
+	}
+	for _, required := range config.RequireContains {
+		if !bytes.Contains(content, []byte(required)) {
+			problems = append(problems, fmt.Sprintf("missing %q", required))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Explain why a markdown input doesn't look like a profile, or return "" when it does or
+// no -md-marker is configured. A file needs only one of the markers, so several profile
+// layouts can be accepted at once; JSON inputs are never checked.
+func markerReason(config Config, fileType string, content []byte) string {
+	if len(config.MarkdownMarkers) == 0 || fileType != FileTypeMarkdown {
+		return ""
+	}
+	for _, marker := range config.MarkdownMarkers {
+		if bytes.Contains(content, []byte(marker)) {
+			return ""
+		}
+	}
+	quoted := make([]string, len(config.MarkdownMarkers))
+	for i, marker := range config.MarkdownMarkers {
+		quoted[i] = fmt.Sprintf("%q", marker)
+	}
+	return "contains none of the -md-marker texts " + strings.Join(quoted, ", ")
+}
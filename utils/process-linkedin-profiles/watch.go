@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch the input folder for new JSON and markdown files until the context is cancelled.
+// A file is dispatched only once no further writes have been seen for the debounce
+// interval, so files still being written by another process aren't picked up early.
+func watchInputFolder(ctx context.Context, watcher *fsnotify.Watcher, debounce time.Duration,
+	dispatch func(filePath string), logger *log.Logger, mutex *sync.Mutex) {
+	pending := make(map[string]*time.Timer)
+	ready := make(chan string)
+
+	for {
+		select {
+		case <-ctx.Done():
+			for _, timer := range pending {
+				timer.Stop()
+			}
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			if detectFileType(event.Name) == FileTypeUnknown {
+				continue
+			}
+
+			// Restart the quiet period on every write
+			if timer, exists := pending[event.Name]; exists {
+				timer.Reset(debounce)
+				continue
+			}
+			filePath := event.Name
+			pending[filePath] = time.AfterFunc(debounce, func() {
+				select {
+				case ready <- filePath:
+				case <-ctx.Done():
+				}
+			})
+
+		case filePath := <-ready:
+			delete(pending, filePath)
+			dispatch(filePath)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logMessage(logger, fmt.Sprintf("WARNING: Watch error - %v", err), mutex)
+		}
+	}
+}
+
+// watchQueue buffers the files detected while watching, for -watch-queue. A single feeder
+// hands them to dispatch in order, so only dispatch waits for a worker token and the watch
+// loop keeps draining events however many files arrive at once.
+type watchQueue struct {
+	files   chan string
+	done    chan struct{}
+	logger  *log.Logger
+	mutex   *sync.Mutex
+	dropped int // Files left out because the queue was full; only touched by the watch loop
+	held    int // Files taken from the queue after cancellation and not dispatched; only touched by the feeder
+}
+
+// Start the feeder, which runs until the context is cancelled or Close is called. dispatch
+// is given the context so it stops waiting for a worker token once it is cancelled, and
+// returns false when the file was held back for that reason.
+func newWatchQueue(ctx context.Context, depth int, dispatch func(ctx context.Context, filePath string) bool, logger *log.Logger, mutex *sync.Mutex) *watchQueue {
+	q := &watchQueue{files: make(chan string, depth), done: make(chan struct{}), logger: logger, mutex: mutex}
+	go func() {
+		defer close(q.done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case filePath, ok := <-q.files:
+				if !ok {
+					return
+				}
+				// Both cases can be ready at once, so a file received after an interrupt is held
+				// back rather than started, as is one still waiting for a token when it comes
+				if ctx.Err() != nil || !dispatch(ctx, filePath) {
+					q.held++
+					return
+				}
+			}
+		}
+	}()
+	return q
+}
+
+// Queue a detected file, dropping it with a warning when the queue is full. A dropped
+// file hasn't been dispatched, so its next write queues it again.
+func (q *watchQueue) enqueue(filePath string) {
+	select {
+	case q.files <- filePath:
+	default:
+		q.dropped++
+		message := fmt.Sprintf("WARNING: Watch queue full (%d files waiting), dropping %s", cap(q.files), filePath)
+		logMessage(q.logger, message, q.mutex)
+		fmt.Fprintln(console, message)
+	}
+}
+
+// Stop the feeder once the watch loop has returned and report what was never dispatched:
+// files dropped while the queue was full and files still waiting in it
+func (q *watchQueue) Close() {
+	close(q.files)
+	<-q.done
+	waiting := len(q.files) + q.held
+	if q.dropped > 0 || waiting > 0 {
+		message := fmt.Sprintf("WARNING: %d watched files were dropped because the queue was full and %d were still queued when watching stopped", q.dropped, waiting)
+		logMessage(q.logger, message, q.mutex)
+		fmt.Fprintln(console, message)
+	}
+}
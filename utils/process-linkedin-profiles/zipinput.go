@@ -0,0 +1,67 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Report whether -input names a zip archive rather than a folder
+func isZipInput(input string) bool {
+	return strings.EqualFold(filepath.Ext(input), ".zip")
+}
+
+// zipInput serves input files straight from a zip archive, so it doesn't need to be
+// extracted to disk first. Entries are addressed by their name within the archive.
+type zipInput struct {
+	reader  *zip.ReadCloser
+	entries map[string]*zip.File
+}
+
+// Open a zip archive of input files
+func openZipInput(path string) (*zipInput, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	input := &zipInput{reader: reader, entries: make(map[string]*zip.File)}
+	for _, file := range reader.File {
+		if !file.FileInfo().IsDir() {
+			input.entries[file.Name] = file
+		}
+	}
+	return input, nil
+}
+
+// Enumerate the JSON and markdown entries at any depth, in archive order
+func (z *zipInput) findInputFiles(found func(filePath string)) {
+	for _, file := range z.reader.File {
+		if _, ok := z.entries[file.Name]; !ok {
+			continue
+		}
+		if fileType := detectFileType(file.Name); fileType == FileTypeJSON || fileType == FileTypeMarkdown {
+			found(file.Name)
+		}
+	}
+}
+
+// Read the content of an entry; safe for concurrent use by workers
+func (z *zipInput) readFile(name string) ([]byte, error) {
+	file, ok := z.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("no entry %s in archive", name)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Close the archive
+func (z *zipInput) Close() error {
+	return z.reader.Close()
+}